@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// encodeExtended encodes a sample rate as an 80-bit IEEE 754 extended precision number, as used by AIFF
+func encodeExtended(value int) []byte {
+	b := make([]byte, 10)
+	if value <= 0 {
+		return b
+	}
+	exponent := 0
+	for v := value; v > 1; v >>= 1 {
+		exponent++
+	}
+	binary.BigEndian.PutUint16(b[0:2], uint16(16383+exponent))
+	binary.BigEndian.PutUint64(b[2:10], uint64(value)<<(63-exponent))
+	return b
+}
+
+func decodeExtended(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7fff)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	value := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		return -value
+	}
+	return value
+}
+
+func writeAiff(filePath string, samples []float64, sampleRate, bitDepth, channels int) error {
+	if err := checkEncodable(samples, bitDepth, channels, 8, 16, 24, 32); err != nil {
+		return err
+	}
+	bytesPerSample := bitDepth / 8
+	frames := len(samples) / channels
+	var comm bytes.Buffer
+	binary.Write(&comm, binary.BigEndian, int16(channels))
+	binary.Write(&comm, binary.BigEndian, uint32(frames))
+	binary.Write(&comm, binary.BigEndian, int16(bitDepth))
+	comm.Write(encodeExtended(sampleRate))
+
+	ssnd := make([]byte, 8, 8+len(samples)*bytesPerSample+1) // offset and block size are both 0
+	for _, value := range quantize(samples, bitDepth) {
+		for shift := bitDepth - 8; shift >= 0; shift -= 8 {
+			ssnd = append(ssnd, byte(value>>shift))
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("FORM")
+	binary.Write(&buf, binary.BigEndian, uint32(4+8+comm.Len()+8+len(ssnd)+len(ssnd)%2))
+	buf.WriteString("AIFF")
+	buf.WriteString("COMM")
+	binary.Write(&buf, binary.BigEndian, uint32(comm.Len()))
+	buf.Write(comm.Bytes())
+	buf.WriteString("SSND")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ssnd)))
+	buf.Write(ssnd)
+	if len(ssnd)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return os.WriteFile(filePath, buf.Bytes(), 0o644)
+}
+
+// decodeAiff decodes uncompressed AIFF and AIFF-C files
+func decodeAiff(data []byte) (*audioData, error) {
+	littleEndian := false
+	var (
+		audio    audioData
+		frames   int
+		soundPos = -1
+	)
+	for pos := 12; pos+8 <= len(data); {
+		chunkID := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if size > len(data)-pos {
+			size = len(data) - pos
+		}
+		chunk := data[pos : pos+size]
+		switch chunkID {
+		case "COMM":
+			if len(chunk) < 18 {
+				return nil, errors.New("invalid AIFF COMM chunk")
+			}
+			audio.Channels = int(binary.BigEndian.Uint16(chunk[0:2]))
+			frames = int(binary.BigEndian.Uint32(chunk[2:6]))
+			audio.BitDepth = int(binary.BigEndian.Uint16(chunk[6:8]))
+			audio.SampleRate = int(math.Round(decodeExtended(chunk[8:18])))
+			if string(data[8:12]) == "AIFC" && len(chunk) >= 22 {
+				switch compression := string(chunk[18:22]); compression {
+				case "NONE", "twos":
+				case "sowt":
+					littleEndian = true
+				default:
+					return nil, fmt.Errorf("unsupported AIFF-C compression: %s", compression)
+				}
+			}
+		case "SSND":
+			if len(chunk) < 8 {
+				return nil, errors.New("invalid AIFF SSND chunk")
+			}
+			soundPos = pos + 8 + int(binary.BigEndian.Uint32(chunk[0:4]))
+		}
+		pos += size + size%2
+	}
+	// the offset of the sound data is not checked while the chunks are read, so it can point past the end of the file
+	if audio.Channels < 1 || audio.BitDepth < 1 || audio.BitDepth > 32 || soundPos < 0 || soundPos > len(data) {
+		return nil, errors.New("invalid AIFF file")
+	}
+	bytesPerSample := (audio.BitDepth + 7) / 8
+	count := max(frames*audio.Channels, 0)
+	if available := (len(data) - soundPos) / bytesPerSample; available < count {
+		count = available
+	}
+	scale := float64(int64(1) << (bytesPerSample*8 - 1))
+	audio.Samples = make([]float64, count)
+	for i := range audio.Samples {
+		b := data[soundPos+i*bytesPerSample : soundPos+(i+1)*bytesPerSample]
+		var value int64
+		for j := 0; j < bytesPerSample; j++ {
+			if littleEndian {
+				value |= int64(b[j]) << (8 * j)
+			} else {
+				value = value<<8 | int64(b[j])
+			}
+		}
+		// sign extend
+		value = value << (64 - 8*bytesPerSample) >> (64 - 8*bytesPerSample)
+		audio.Samples[i] = float64(value) / scale
+	}
+	return &audio, nil
+}
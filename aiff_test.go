@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAiffRoundTrip(t *testing.T) {
+	samples := make([]float64, 2*1000)
+	for i := range samples {
+		samples[i] = 0.8 * math.Sin(float64(i)*0.01)
+	}
+	for _, bitDepth := range []int{8, 16, 24, 32} {
+		filePath := filepath.Join(t.TempDir(), "test.aiff")
+		if err := writeAiff(filePath, samples, 48000, bitDepth, 2); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		audio, err := decodeAudio(data)
+		if err != nil {
+			t.Fatalf("%d-bit: %v", bitDepth, err)
+		}
+		if audio.SampleRate != 48000 || audio.Channels != 2 || audio.BitDepth != bitDepth || len(audio.Samples) != len(samples) {
+			t.Fatalf("%d-bit: got %d Hz, %d channels, %d-bit and %d samples", bitDepth, audio.SampleRate, audio.Channels, audio.BitDepth, len(audio.Samples))
+		}
+		tolerance := 2 / math.Pow(2, float64(bitDepth-1))
+		for i, sample := range audio.Samples {
+			if math.Abs(sample-samples[i]) > tolerance {
+				t.Fatalf("%d-bit: sample %d is %f, not %f", bitDepth, i, sample, samples[i])
+			}
+		}
+	}
+}
+
+// aiffWithSoundOffset returns a 16-bit mono AIFF file with one frame, and the given offset in the SSND chunk
+func aiffWithSoundOffset(offset uint32) []byte {
+	var comm bytes.Buffer
+	binary.Write(&comm, binary.BigEndian, int16(1))
+	binary.Write(&comm, binary.BigEndian, uint32(1))
+	binary.Write(&comm, binary.BigEndian, int16(16))
+	comm.Write(encodeExtended(44100))
+	var buf bytes.Buffer
+	buf.WriteString("FORM")
+	binary.Write(&buf, binary.BigEndian, uint32(4+8+comm.Len()+8+10))
+	buf.WriteString("AIFF")
+	buf.WriteString("COMM")
+	binary.Write(&buf, binary.BigEndian, uint32(comm.Len()))
+	buf.Write(comm.Bytes())
+	buf.WriteString("SSND")
+	binary.Write(&buf, binary.BigEndian, uint32(10))
+	binary.Write(&buf, binary.BigEndian, offset)
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	buf.Write([]byte{0x12, 0x34})
+	return buf.Bytes()
+}
+
+func TestAiffInvalidSoundOffset(t *testing.T) {
+	if _, err := decodeAudio(aiffWithSoundOffset(0x7fffff00)); err == nil {
+		t.Fatal("an SSND offset past the end of the file was accepted")
+	}
+	audio, err := decodeAudio(aiffWithSoundOffset(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audio.Samples) != 0 {
+		t.Fatalf("got %d samples from the padding after the offset", len(audio.Samples))
+	}
+}
+
+func TestAiffTruncated(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "test.aiff")
+	if err := writeAiff(filePath, []float64{0.1, -0.2, 0.3, -0.4}, 44100, 24, 1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every truncated file either decodes or returns an error, without panicking
+	for length := 12; length < len(data); length++ {
+		decodeAudio(data[:length])
+	}
+}
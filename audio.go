@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/xyproto/playsample"
+)
+
+const (
+	defaultAudioOutput = "auto"
+	defaultRecordPath  = "kickpad-recording.wav"
+
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+
+	// maxWavDataSize is the most sample data that fits in a WAV file, after the 36 bytes of the header that are counted in its size
+	maxWavDataSize = math.MaxUint32 - 36
+)
+
+// audioOutputs are the names of the audio outputs that can be selected with --audio
+var audioOutputs = []string{"auto", "playsample", "null", "wav"}
+
+// AudioOutput is where the mixer sends its stream of interleaved stereo frames
+type AudioOutput interface {
+	Write(frames []float32) error
+	Close() error
+}
+
+var audioOutputName = "none"
+
+// openAudioOutput opens the audio output with the given name. "auto" is the sound card if it works, or no output if not.
+// "wav" records everything that is played to recordPath. If the output can not be opened, no output is returned with the error.
+func openAudioOutput(name string, sampleRate int, recordPath string) (AudioOutput, error) {
+	switch name {
+	case "auto":
+		output, err := newPlaysampleOutput(sampleRate)
+		if err != nil {
+			return nullOutput{}, fmt.Errorf("no sound card, nothing will be heard: %v", err)
+		}
+		return output, nil
+	case "playsample":
+		output, err := newPlaysampleOutput(sampleRate)
+		if err != nil {
+			return nullOutput{}, err
+		}
+		return output, nil
+	case "null":
+		return nullOutput{}, nil
+	case "wav":
+		output, err := newWavOutput(recordPath, sampleRate)
+		if err != nil {
+			return nullOutput{}, err
+		}
+		return output, nil
+	}
+	return nullOutput{}, fmt.Errorf("unknown audio output %q, it can be one of %v", name, audioOutputs)
+}
+
+// playsampleOutput plays on the sound card, with the same backend as playsample: SDL2, or ffplay for builds with -tags ff
+type playsampleOutput struct {
+	player *playsample.Player
+	stream AudioOutput
+}
+
+func newPlaysampleOutput(sampleRate int) (*playsampleOutput, error) {
+	player := playsample.NewPlayer()
+	if !player.Initialized {
+		return nil, errors.New("the audio player failed to initialize")
+	}
+	stream, err := openAudioStream(sampleRate)
+	if err != nil {
+		player.Close()
+		return nil, err
+	}
+	return &playsampleOutput{player: player, stream: stream}, nil
+}
+
+func (o *playsampleOutput) Write(frames []float32) error {
+	return o.stream.Write(frames)
+}
+
+func (o *playsampleOutput) Close() error {
+	err := o.stream.Close()
+	o.player.Close()
+	return err
+}
+
+// nullOutput throws the sound away, for machines without a sound card
+type nullOutput struct{}
+
+func (nullOutput) Write([]float32) error { return nil }
+
+func (nullOutput) Close() error { return nil }
+
+// wavOutput records the sound to a 16-bit stereo WAV file. The sizes in the header are written when it is closed.
+type wavOutput struct {
+	file   *os.File
+	w      *bufio.Writer
+	frames int
+	buf    []byte
+}
+
+func newWavOutput(filePath string, sampleRate int) (*wavOutput, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	o := &wavOutput{file: file, w: bufio.NewWriter(file)}
+	if err := writeWavHeader(o.w, wavFormatPCM, 2, sampleRate, 16, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *wavOutput) Write(frames []float32) error {
+	// the sizes in the header are 32-bit, so the recording stops before it is 4 GiB
+	if int64(o.frames+len(frames)/2)*2*2 > maxWavDataSize {
+		return errors.New("the WAV recording is full, it can not be larger than 4 GiB")
+	}
+	o.buf = o.buf[:0]
+	for _, sample := range frames {
+		o.buf = binary.LittleEndian.AppendUint16(o.buf, uint16(int16(math.Round(clamp(float64(sample), -1, 1)*math.MaxInt16))))
+	}
+	o.frames += len(frames) / 2
+	_, err := o.w.Write(o.buf)
+	return err
+}
+
+func (o *wavOutput) Close() error {
+	if err := o.w.Flush(); err != nil {
+		o.file.Close()
+		return err
+	}
+	dataSize := uint32(o.frames * 2 * 2)
+	for _, field := range []struct {
+		offset int64
+		value  uint32
+	}{{4, 36 + dataSize}, {40, dataSize}} {
+		if _, err := o.file.WriteAt(binary.LittleEndian.AppendUint32(nil, field.value), field.offset); err != nil {
+			o.file.Close()
+			return err
+		}
+	}
+	return o.file.Close()
+}
+
+// writeWavHeader writes the 44 byte header of a WAV file, with the given size of the sample data
+func writeWavHeader(w io.Writer, format, channels, sampleRate, bitsPerSample int, dataSize uint32) error {
+	bytesPerFrame := channels * bitsPerSample / 8
+	header := []any{
+		[]byte("RIFF"), dataSize + 36, []byte("WAVE"),
+		[]byte("fmt "), uint32(16), uint16(format), uint16(channels),
+		uint32(sampleRate), uint32(sampleRate * bytesPerFrame), uint16(bytesPerFrame), uint16(bitsPerSample),
+		[]byte("data"), dataSize,
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
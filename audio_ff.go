@@ -0,0 +1,59 @@
+//go:build ff
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+)
+
+// ffplayStream streams audio to ffplay, as a WAV file without an end
+type ffplayStream struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	w     *bufio.Writer
+	buf   []byte
+}
+
+// openAudioStream starts ffplay, which plays what is written to it
+func openAudioStream(sampleRate int) (AudioOutput, error) {
+	path, err := exec.LookPath("ffplay")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, "-nodisp", "-autoexit", "-loglevel", "quiet", "-fflags", "nobuffer", "-i", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start ffplay: %v", err)
+	}
+	s := &ffplayStream{cmd: cmd, stdin: stdin, w: bufio.NewWriter(stdin)}
+	// the sizes are unknown, so the largest possible sizes are used
+	if err := writeWavHeader(s.w, wavFormatFloat, 2, sampleRate, 32, math.MaxUint32-36); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ffplayStream) Write(frames []float32) error {
+	s.buf = s.buf[:0]
+	for _, sample := range frames {
+		s.buf = binary.LittleEndian.AppendUint32(s.buf, math.Float32bits(sample))
+	}
+	if _, err := s.w.Write(s.buf); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *ffplayStream) Close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
@@ -0,0 +1,55 @@
+//go:build !ff
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// sdlStream queues audio on an SDL2 audio device, which must be initialized first, like playsample.NewPlayer does
+type sdlStream struct {
+	device sdl.AudioDeviceID
+	// maxQueued is the most bytes that are queued, so that the latency stays low if the mixer gets ahead of the device
+	maxQueued uint32
+	buf       []byte
+}
+
+// openAudioStream opens the default audio device
+func openAudioStream(sampleRate int) (AudioOutput, error) {
+	desired := sdl.AudioSpec{
+		Freq:     int32(sampleRate),
+		Format:   sdl.AUDIO_F32SYS,
+		Channels: 2,
+		Samples:  mixerBlockSize,
+	}
+	var obtained sdl.AudioSpec
+	device, err := sdl.OpenAudioDevice("", false, &desired, &obtained, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audio device: %v", err)
+	}
+	sdl.PauseAudioDevice(device, false)
+	const bytesPerFrame = 2 * 4
+	maxQueued := uint32(2 * mixerLatency.Seconds() * float64(sampleRate) * bytesPerFrame)
+	return &sdlStream{device: device, maxQueued: maxQueued}, nil
+}
+
+func (s *sdlStream) Write(frames []float32) error {
+	for sdl.GetQueuedAudioSize(s.device) > s.maxQueued {
+		time.Sleep(time.Millisecond)
+	}
+	s.buf = s.buf[:0]
+	for _, sample := range frames {
+		s.buf = binary.NativeEndian.AppendUint32(s.buf, math.Float32bits(sample))
+	}
+	return sdl.QueueAudio(s.device, s.buf)
+}
+
+func (s *sdlStream) Close() error {
+	sdl.CloseAudioDevice(s.device)
+	return nil
+}
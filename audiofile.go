@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-audio/wav"
+	"github.com/xyproto/playsample"
+	"github.com/xyproto/synth"
+)
+
+// targetSampleRate is the sample rate that loaded target waveforms are resampled to
+const targetSampleRate = 44100
+
+// audioData is decoded audio, with interleaved samples in the range [-1, 1]
+type audioData struct {
+	Samples    []float64
+	SampleRate int
+	BitDepth   int
+	Channels   int
+}
+
+var (
+	exportFormats     = []string{"WAV", "FLAC", "AIFF"}
+	exportExtensions  = []string{".wav", ".flac", ".aiff"}
+	exportFormatIndex int32
+)
+
+// decodeAudio decodes WAV, FLAC or AIFF data, based on the first bytes of the data
+func decodeAudio(data []byte) (*audioData, error) {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return decodeWav(data)
+	case len(data) >= 4 && string(data[:4]) == "fLaC":
+		return decodeFlac(data)
+	case len(data) >= 12 && string(data[:4]) == "FORM" && (string(data[8:12]) == "AIFF" || string(data[8:12]) == "AIFC"):
+		return decodeAiff(data)
+	}
+	return nil, errors.New("unsupported audio format, only WAV, FLAC and AIFF are supported")
+}
+
+func decodeWav(data []byte) (*audioData, error) {
+	decoder := wav.NewDecoder(bytes.NewReader(data))
+	buffer, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return nil, err
+	}
+	if buffer.Format == nil || buffer.Format.NumChannels < 1 {
+		return nil, errors.New("invalid WAV format")
+	}
+	bitDepth := buffer.SourceBitDepth
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+	scale := float64(int64(1) << (bitDepth - 1))
+	offset := 0.0
+	if bitDepth == 8 { // 8-bit WAV samples are unsigned
+		offset = scale
+	}
+	samples := make([]float64, len(buffer.Data))
+	for i, sample := range buffer.Data {
+		samples[i] = (float64(sample) - offset) / scale
+	}
+	return &audioData{Samples: samples, SampleRate: buffer.Format.SampleRate, BitDepth: bitDepth, Channels: buffer.Format.NumChannels}, nil
+}
+
+func readAudioFile(filePath string) (*audioData, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAudio(data)
+}
+
+// writeAudioFile writes the samples as WAV, FLAC or AIFF, depending on the file extension
+func writeAudioFile(filePath string, samples []float64, sampleRate, bitDepth, channels int) error {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".flac":
+		return writeFlac(filePath, samples, sampleRate, bitDepth, channels)
+	case ".aif", ".aiff":
+		return writeAiff(filePath, samples, sampleRate, bitDepth, channels)
+	}
+	return writeWav(filePath, samples, sampleRate, bitDepth, channels)
+}
+
+// memoryFile is an in-memory io.WriteSeeker, for encoding audio without writing it to disk
+type memoryFile struct {
+	data []byte
+	pos  int
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	if end := f.pos + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	n := copy(f.data[f.pos:], p)
+	f.pos += n
+	return n, nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = int64(f.pos) + offset
+	case io.SeekEnd:
+		pos = int64(len(f.data)) + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative seek position")
+	}
+	f.pos = int(pos)
+	return pos, nil
+}
+
+// encodeWav returns the samples as the bytes of a WAV file
+func encodeWav(samples []float64, sampleRate, bitDepth, channels int) ([]byte, error) {
+	var f memoryFile
+	if err := playsample.SaveToWav(&f, samples, sampleRate, bitDepth, channels); err != nil {
+		return nil, err
+	}
+	return f.data, nil
+}
+
+func hasAudioExtension(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".wav", ".flac", ".aif", ".aiff":
+		return true
+	}
+	return false
+}
+
+// quantize converts samples in the range [-1, 1] to signed integers with the given bit depth
+func quantize(samples []float64, bitDepth int) []int64 {
+	maxValue := float64(int64(1)<<(bitDepth-1)) - 1
+	ints := make([]int64, len(samples))
+	for i, sample := range samples {
+		ints[i] = int64(math.Round(clamp(sample, -1, 1) * maxValue))
+	}
+	return ints
+}
+
+// targetWaveform mixes the audio down to mono and resamples it to targetSampleRate, for use as a GA target
+func targetWaveform(audio *audioData) []float64 {
+	frames := len(audio.Samples) / audio.Channels
+	mono := make([]float64, frames)
+	for i := 0; i < frames; i++ {
+		sum := 0.0
+		for c := 0; c < audio.Channels; c++ {
+			sum += audio.Samples[i*audio.Channels+c]
+		}
+		mono[i] = sum / float64(audio.Channels)
+	}
+	if audio.SampleRate != targetSampleRate && audio.SampleRate > 0 {
+		mono = synth.Resample(mono, audio.SampleRate, targetSampleRate)
+	}
+	return mono
+}
+
+func checkEncodable(samples []float64, bitDepth, channels int, supportedBitDepths ...int) error {
+	if len(samples) == 0 {
+		return errors.New("cannot save empty waveform: no samples provided")
+	}
+	if channels <= 0 || len(samples)%channels != 0 {
+		return fmt.Errorf("number of samples (%d) is not a multiple of channels (%d)", len(samples), channels)
+	}
+	for _, supported := range supportedBitDepths {
+		if bitDepth == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported bit depth: %d", bitDepth)
+}
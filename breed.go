@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+var (
+	favorites       [numPads]bool
+	breedStrength   float32 = 0.2
+	breedGeneration int
+)
+
+func favoritePads() []int {
+	var padIndices []int
+	for i, favorite := range favorites {
+		if favorite {
+			padIndices = append(padIndices, i)
+		}
+	}
+	return padIndices
+}
+
+// breed replaces all pads that are not favorites with children of the favorites.
+// The strength (0 to 1) is both the chance that a parameter is mutated and how much it is changed.
+func breed(strength float64) error {
+	parents := favoritePads()
+	if len(parents) == 0 {
+		return errors.New("mark one or more pads as favorites first")
+	}
+	var children []int
+	for i := 0; i < numPads; i++ {
+		if !favorites[i] {
+			children = append(children, i)
+		}
+	}
+	if len(children) == 0 {
+		return errors.New("all pads are favorites, there is no room for children")
+	}
+	recordEdit(fmt.Sprintf("Breed generation %d", breedGeneration+1), children...)
+	parentSettings := make([]*synth.Settings, len(parents))
+	for i, padIndex := range parents {
+		parentSettings[i] = synth.CopySettings(pads[padIndex])
+	}
+	for i := 0; i < len(children); i += 2 {
+		parent1 := parentSettings[rand.Intn(len(parentSettings))]
+		parent2 := parentSettings[rand.Intn(len(parentSettings))]
+		child1, child2 := singlePointCrossover(parent1, parent2)
+		for j, child := range []*synth.Settings{child1, child2} {
+			if i+j >= len(children) {
+				break
+			}
+			mutateSettingsWith(globalRandom{}, child, true, strength, strength)
+			pads[children[i+j]] = child
+			// a sample or the layers that were on the pad before would hide or change the sound of the child
+			padOpts[children[i+j]] = newPadOptions()
+			roundRobinIndex[children[i+j]] = 0
+		}
+	}
+	breedGeneration++
+	return nil
+}
+
+func createBreedWidget() g.Widget {
+	var favoriteRows []g.Widget
+	for row := 0; row < 4; row++ {
+		var checkboxes []g.Widget
+		for col := 0; col < 4; col++ {
+			padIndex := row*4 + col
+			checkboxes = append(checkboxes, g.Checkbox(fmt.Sprintf("%2d##favorite", padIndex+1), &favorites[padIndex]))
+		}
+		favoriteRows = append(favoriteRows, g.Row(checkboxes...))
+	}
+	return g.Column(
+		g.Label("Favorite pads (parents):"),
+		g.Column(favoriteRows...),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Label("Mutation strength"),
+			g.SliderFloat(&breedStrength, 0.01, 1.0).Size(150),
+		),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Button("Breed").OnClick(func() {
+				if err := breed(float64(breedStrength)); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Could not breed: %v", err))
+					return
+				}
+				setStatusMessage(fmt.Sprintf("Generation %d: bred %d pads from %d favorites", breedGeneration, numPads-len(favoritePads()), len(favoritePads())))
+			}),
+			g.Button("Clear favorites").OnClick(func() {
+				favorites = [numPads]bool{}
+			}),
+		),
+	)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/AllenDang/cimgui-go/imgui"
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+// padDragDropType is the ImGui payload type used when dragging one pad onto another
+const padDragDropType = "KICKPAD_PAD"
+
+var (
+	// padClipboard is the last copied pad, used when the system clipboard does not contain pad settings,
+	// or when it still contains padClipboardText, which is the same pad without its sample
+	padClipboard     *wavMetadata
+	padClipboardText string
+
+	// padInUse is true for pads that have been changed since they were randomized
+	padInUse [numPads]bool
+
+	dragSourcePad = -1
+)
+
+// copyPad copies the settings of a pad, both internally and to the system clipboard, as JSON.
+// A sample is only copied internally, since the recording is too large for the system clipboard.
+func copyPad(padIndex int) {
+	meta := wavMetadata{
+		Version:  versionString,
+		Options:  padOpts[padIndex].clone(),
+		Settings: storeSettings(pads[padIndex]),
+	}
+	padClipboard = &meta
+	text := meta
+	text.Options.Sample = nil
+	data, err := json.MarshalIndent(text, "", "  ")
+	if err != nil {
+		setStatusMessage(fmt.Sprintf("Error: Failed to copy %s: %v", padLabel(padIndex), err))
+		return
+	}
+	padClipboardText = string(data)
+	imgui.SetClipboardText(padClipboardText)
+	setStatusMessage(fmt.Sprintf("Copied %s", padLabel(padIndex)))
+}
+
+// parsePadJSON reads pad settings that were copied from Kickpad
+func parsePadJSON(text string) (*wavMetadata, error) {
+	var meta wavMetadata
+	if err := json.Unmarshal([]byte(text), &meta); err != nil {
+		return nil, err
+	}
+	if meta.Settings.SampleRate <= 0 || meta.Settings.Channels <= 0 || meta.Settings.Duration <= 0 {
+		return nil, errors.New("the clipboard does not contain pad settings")
+	}
+	return &meta, nil
+}
+
+// pastePad replaces the settings of a pad with the settings from the system clipboard,
+// or with the last copied pad if the system clipboard does not contain any
+func pastePad(padIndex int) {
+	text := imgui.ClipboardText()
+	meta, err := parsePadJSON(text)
+	if err != nil || (padClipboard != nil && text == padClipboardText) {
+		if padClipboard == nil {
+			setStatusMessage(fmt.Sprintf("Error: Nothing to paste: %v", err))
+			return
+		}
+		meta = padClipboard
+	}
+	recordEdit(fmt.Sprintf("%s: Paste", padLabel(padIndex)), padIndex)
+	// the pad gets its own copy of the options, so that pasting twice does not make two pads share layers or a sample
+	pasted := *meta
+	pasted.Options = meta.Options.clone()
+	restoreMetadata(padIndex, &pasted)
+	setStatusMessage(fmt.Sprintf("Pasted a %s into %s", meta.Settings.SoundType, padLabel(padIndex)))
+}
+
+func swapPads(a, b int) {
+	if a == b {
+		return
+	}
+	recordEdit(fmt.Sprintf("Swap %s and %s", padLabel(a), padLabel(b)), a, b)
+	pads[a], pads[b] = pads[b], pads[a]
+	padOpts[a], padOpts[b] = padOpts[b], padOpts[a]
+	padInUse[a], padInUse[b] = padInUse[b], padInUse[a]
+	favorites[a], favorites[b] = favorites[b], favorites[a]
+	roundRobinIndex[a], roundRobinIndex[b] = 0, 0
+	if activePadIndex == a {
+		activePadIndex = b
+	} else if activePadIndex == b {
+		activePadIndex = a
+	}
+	setStatusMessage(fmt.Sprintf("Swapped %s and %s", padLabel(a), padLabel(b)))
+}
+
+// nextFreePad returns the first pad after the given pad that is not in use, or -1
+func nextFreePad(padIndex int) int {
+	for i := 1; i < numPads; i++ {
+		if candidate := (padIndex + i) % numPads; !padInUse[candidate] {
+			return candidate
+		}
+	}
+	return -1
+}
+
+// duplicatePad copies a pad to the next free pad, and makes that pad active
+func duplicatePad(padIndex int) {
+	target := nextFreePad(padIndex)
+	if target < 0 {
+		setStatusMessage("Error: There are no free pads, use copy and paste instead")
+		return
+	}
+	recordEdit(fmt.Sprintf("Duplicate %s to %s", padLabel(padIndex), padLabel(target)), target)
+	pads[target] = synth.CopySettings(pads[padIndex])
+	padOpts[target] = padOpts[padIndex].clone()
+	roundRobinIndex[target] = 0
+	activePadIndex = target
+	setStatusMessage(fmt.Sprintf("Duplicated %s to %s", padLabel(padIndex), padLabel(target)))
+}
+
+// padDragDrop lets the previous widget, a pad button, be dragged onto another pad to swap the two.
+// The loaded WAV can also be dragged onto it, see sampleDragSource.
+func padDragDrop(padIndex int) g.Widget {
+	return g.Custom(func() {
+		if imgui.BeginDragDropSource() {
+			dragSourcePad = padIndex
+			imgui.SetDragDropPayload(padDragDropType, 0, 0)
+			g.Label(fmt.Sprintf("Swap %s with...", padLabel(padIndex))).Build()
+			imgui.EndDragDropSource()
+		}
+		if imgui.BeginDragDropTarget() {
+			if payload := imgui.AcceptDragDropPayload(padDragDropType); payload.CData != nil && dragSourcePad >= 0 {
+				swapPads(dragSourcePad, padIndex)
+				dragSourcePad = -1
+			}
+			if payload := imgui.AcceptDragDropPayload(sampleDragDropType); payload.CData != nil && len(loadedWaveform) > 0 {
+				setPadSample(padIndex, loadedWaveform, loadedWaveformName)
+			}
+			imgui.EndDragDropTarget()
+		}
+	})
+}
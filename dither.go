@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	ditherNone   = 0
+	ditherTPDF   = 1
+	ditherShaped = 2
+
+	// maxDitherBitDepth is the highest bit depth that is dithered, since the noise of 24-bit audio is far below hearing
+	maxDitherBitDepth = 16
+	// ditherSeed makes the noise the same every time a pad is exported, so that exports are reproducible
+	ditherSeed = 0x6b69636b
+)
+
+var (
+	ditherModes        = []string{"None", "TPDF", "TPDF + noise shaping"}
+	exportDither int32 = ditherTPDF
+
+	// noiseShaping is the error feedback filter of the noise shaping, which moves the noise up to where hearing is
+	// less sensitive, by Wannamaker's 3-tap weighted filter for 44.1 kHz
+	noiseShaping = []float64{1.623, -0.982, 0.109}
+)
+
+// dither returns the samples quantized to the given bit depth, with triangular (TPDF) noise of ±1 LSB added before
+// rounding, so that the error of the quantization is noise instead of distortion. The returned samples are on the
+// same grid as quantize, so that writing them does not round them again. The noise depends only on the seed.
+// The samples are interleaved, and the noise of each channel is shaped by itself.
+func dither(samples []float64, bitDepth, channels, mode int, seed int64) []float64 {
+	if mode == ditherNone || bitDepth > maxDitherBitDepth || len(samples) == 0 {
+		return samples
+	}
+	maxValue := float64(int64(1)<<(bitDepth-1)) - 1
+	random := rand.New(rand.NewSource(seed))
+	errs := make([][]float64, channels) // the latest quantization errors of each channel, in LSB
+	for c := range errs {
+		errs[c] = make([]float64, len(noiseShaping))
+	}
+	out := make([]float64, len(samples))
+	for i, sample := range samples {
+		errs := errs[i%channels]
+		value := sample * maxValue
+		if mode == ditherShaped {
+			for k, weight := range noiseShaping {
+				value -= weight * errs[k]
+			}
+		}
+		quantized := math.Round(value + random.Float64() - random.Float64())
+		copy(errs[1:], errs)
+		// the error is taken before clipping, so that a clipped sample does not make the noise shaping ring
+		errs[0] = quantized - value
+		out[i] = clamp(quantized, -maxValue, maxValue) / maxValue
+	}
+	return out
+}
+
+// padDitherSeed returns the seed of the dither of a pad, or of one variant of it
+func padDitherSeed(padIndex, variant int) int64 {
+	return ditherSeed + int64(padIndex)<<16 + int64(variant)
+}
+
+func createDitherWidget() g.Widget {
+	return g.Row(
+		g.Label("Dither"),
+		g.Combo("##exportDither", ditherModes[exportDither], ditherModes, &exportDither).Size(170),
+		g.Label("(only 16-bit pads are dithered)"),
+	)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+// The effects of a pad are applied in this order, after the sound has been generated.
+// Every effect is bypassed unless it is enabled, so that pads from older kit files sound the same as before.
+
+// eqEffect is a parametric EQ, with a low shelf, a peak and a high shelf
+type eqEffect struct {
+	Enabled      bool
+	LowGain      float64 // dB, below lowShelfFrequency
+	MidFrequency float64
+	MidGain      float64 // dB
+	MidQ         float64
+	HighGain     float64 // dB, above highShelfFrequency
+}
+
+type compressorEffect struct {
+	Enabled   bool
+	Threshold float64 // dB
+	Ratio     float64
+	Attack    float64 // ms
+	Release   float64 // ms
+	Makeup    float64 // dB
+}
+
+// transientEffect makes the start of a hit louder or softer (Attack), and the rest of it louder or softer (Sustain)
+type transientEffect struct {
+	Enabled bool
+	Attack  float64 // -1 to 1
+	Sustain float64 // -1 to 1
+}
+
+// saturationEffect is a soft clipper followed by a bitcrusher
+type saturationEffect struct {
+	Enabled    bool
+	Drive      float64 // 0 to 1
+	Bits       int     // 0 for no bit reduction
+	Downsample int     // 0 or 1 for no sample rate reduction
+}
+
+// reverbEffect is a short room reverb
+type reverbEffect struct {
+	Enabled bool
+	Mix     float64 // 0 to 1
+	Size    float64 // 0 to 1
+	Damping float64 // 0 to 1
+}
+
+type padEffects struct {
+	EQ         eqEffect
+	Compressor compressorEffect
+	Transient  transientEffect
+	Saturation saturationEffect
+	Reverb     reverbEffect
+}
+
+const (
+	lowShelfFrequency  = 100
+	highShelfFrequency = 8000
+	maxReverbTail      = 0.6 // seconds
+)
+
+var trainWithEffects bool
+
+func (e padEffects) enabled() bool {
+	return e.EQ.Enabled || e.Compressor.Enabled || e.Transient.Enabled || e.Saturation.Enabled || e.Reverb.Enabled
+}
+
+// key identifies the effects in the render cache
+func (e padEffects) key() string {
+	data, _ := json.Marshal(e)
+	return string(data)
+}
+
+// defaultEffects returns the effects of a new pad, which are all bypassed, with the parameters that the sliders start at
+func defaultEffects() padEffects {
+	var e padEffects
+	e.setDefaults()
+	return e
+}
+
+// setDefaults sets the parameters of the EQ and the compressor to their defaults, if they have never been set.
+// Pads from older kit files have no parameters, and a ratio or mid frequency of 0 is not valid, so the other
+// parameters of an effect are only replaced when it is 0. A threshold of 0 dB is kept, as long as the ratio is set.
+func (e *padEffects) setDefaults() {
+	if e.EQ.MidFrequency == 0 {
+		e.EQ.MidFrequency, e.EQ.MidQ = 1000, 0.7
+	}
+	if e.Compressor.Ratio == 0 {
+		e.Compressor.Threshold, e.Compressor.Ratio, e.Compressor.Attack, e.Compressor.Release = -18, 4, 5, 100
+	}
+}
+
+// apply returns a processed copy of the samples
+func (e padEffects) apply(samples []float64, sampleRate int) []float64 {
+	out := append([]float64(nil), samples...)
+	if e.EQ.Enabled {
+		e.EQ.apply(out, sampleRate)
+	}
+	if e.Compressor.Enabled {
+		e.Compressor.apply(out, sampleRate)
+	}
+	if e.Transient.Enabled {
+		e.Transient.apply(out, sampleRate)
+	}
+	if e.Saturation.Enabled {
+		e.Saturation.apply(out)
+	}
+	if e.Reverb.Enabled {
+		out = e.Reverb.apply(out, sampleRate)
+	}
+	return out
+}
+
+// biquad is a second order filter, with coefficients from the Audio EQ Cookbook by Robert Bristow-Johnson
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *biquad {
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+func peakFilter(frequency, gain, q float64, sampleRate int) *biquad {
+	a := math.Pow(10, gain/40)
+	w := 2 * math.Pi * frequency / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * q)
+	return newBiquad(1+alpha*a, -2*math.Cos(w), 1-alpha*a, 1+alpha/a, -2*math.Cos(w), 1-alpha/a)
+}
+
+func shelfFilter(frequency, gain float64, high bool, sampleRate int) *biquad {
+	a := math.Pow(10, gain/40)
+	w := 2 * math.Pi * frequency / float64(sampleRate)
+	cos := math.Cos(w)
+	alpha := math.Sin(w) / 2 * math.Sqrt2 // a shelf slope of 1
+	sq := 2 * math.Sqrt(a) * alpha
+	if high {
+		return newBiquad(a*((a+1)+(a-1)*cos+sq), -2*a*((a-1)+(a+1)*cos), a*((a+1)+(a-1)*cos-sq),
+			(a+1)-(a-1)*cos+sq, 2*((a-1)-(a+1)*cos), (a+1)-(a-1)*cos-sq)
+	}
+	return newBiquad(a*((a+1)-(a-1)*cos+sq), 2*a*((a-1)-(a+1)*cos), a*((a+1)-(a-1)*cos-sq),
+		(a+1)+(a-1)*cos+sq, -2*((a-1)+(a+1)*cos), (a+1)+(a-1)*cos-sq)
+}
+
+func (f *biquad) process(samples []float64) {
+	for i, x := range samples {
+		y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+		f.x2, f.x1 = f.x1, x
+		f.y2, f.y1 = f.y1, y
+		samples[i] = y
+	}
+}
+
+func (e eqEffect) apply(samples []float64, sampleRate int) {
+	nyquist := float64(sampleRate) / 2
+	midFrequency := math.Min(e.MidFrequency, nyquist*0.9)
+	shelfFilter(lowShelfFrequency, e.LowGain, false, sampleRate).process(samples)
+	peakFilter(midFrequency, e.MidGain, math.Max(e.MidQ, 0.1), sampleRate).process(samples)
+	shelfFilter(math.Min(highShelfFrequency, nyquist*0.9), e.HighGain, true, sampleRate).process(samples)
+}
+
+// smoothing returns the coefficient of a one-pole smoother that takes the given time in milliseconds
+func smoothing(ms float64, sampleRate int) float64 {
+	return math.Exp(-1 / (ms / 1000 * float64(sampleRate)))
+}
+
+func follow(envelope, level, attack, release float64) float64 {
+	if level > envelope {
+		return attack*envelope + (1-attack)*level
+	}
+	return release*envelope + (1-release)*level
+}
+
+func (e compressorEffect) apply(samples []float64, sampleRate int) {
+	threshold := e.Threshold
+	ratio := math.Max(e.Ratio, 1)
+	attack := smoothing(e.Attack, sampleRate)
+	release := smoothing(e.Release, sampleRate)
+	makeup := math.Pow(10, e.Makeup/20)
+	reduction := 0.0 // dB
+	for i, x := range samples {
+		level := 20 * math.Log10(math.Abs(x)+1e-9)
+		target := 0.0
+		if level > threshold {
+			target = (level - threshold) * (1 - 1/ratio)
+		}
+		reduction = follow(reduction, target, attack, release)
+		samples[i] = x * math.Pow(10, -reduction/20) * makeup
+	}
+}
+
+func (e transientEffect) apply(samples []float64, sampleRate int) {
+	fastAttack, slowAttack := smoothing(0.5, sampleRate), smoothing(20, sampleRate)
+	release := smoothing(100, sampleRate)
+	var fast, slow float64
+	for i, x := range samples {
+		level := math.Abs(x)
+		fast = follow(fast, level, fastAttack, release)
+		slow = follow(slow, level, slowAttack, release)
+		// transient is close to 1 while the fast envelope is ahead of the slow one, at the start of a hit
+		transient := 0.0
+		if fast > 1e-9 {
+			transient = clamp((fast-slow)/fast, 0, 1)
+		}
+		samples[i] = x * (1 + clamp(e.Attack, -1, 1)*transient) * (1 + clamp(e.Sustain, -1, 1)*(1-transient))
+	}
+}
+
+func (e saturationEffect) apply(samples []float64) {
+	gain := 1 + 9*clamp(e.Drive, 0, 1)
+	normalize := math.Tanh(gain)
+	levels := 0.0
+	if e.Bits > 0 {
+		levels = math.Pow(2, float64(e.Bits-1))
+	}
+	held := 0.0
+	for i, x := range samples {
+		if e.Drive > 0 {
+			x = math.Tanh(gain*x) / normalize
+		}
+		if levels > 0 {
+			x = math.Round(x*levels) / levels
+		}
+		if e.Downsample > 1 {
+			if i%e.Downsample == 0 {
+				held = x
+			}
+			x = held
+		}
+		samples[i] = x
+	}
+}
+
+// combDelays and allpassDelays are the delays of the Freeverb reverb, in samples at 44.1 kHz
+var (
+	combDelays    = []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	allpassDelays = []int{556, 441, 341, 225}
+)
+
+// apply returns the samples with a reverb tail, using parallel comb filters followed by allpass filters, like Freeverb
+func (e reverbEffect) apply(samples []float64, sampleRate int) []float64 {
+	size := clamp(e.Size, 0, 1)
+	tail := int(maxReverbTail * (0.3 + 0.7*size) * float64(sampleRate))
+	out := make([]float64, len(samples)+tail)
+	copy(out, samples)
+	wet := make([]float64, len(out))
+	scale := float64(sampleRate) / 44100
+	// a small room has short delays and a quick decay. The input of the comb filters is scaled down by
+	// as much as the feedback adds at low frequencies, so that the reverb of a kick is about as loud as the dry sound.
+	roomScale := 0.4 + 0.6*size
+	feedback := 0.7 + 0.25*size
+	damping := clamp(e.Damping, 0, 1) * 0.4
+	inputGain := 1 - feedback
+	for _, delay := range combDelays {
+		buffer := make([]float64, max(1, int(float64(delay)*scale*roomScale)))
+		filtered := 0.0
+		for i := range out {
+			pos := i % len(buffer)
+			delayed := buffer[pos]
+			filtered = delayed*(1-damping) + filtered*damping
+			buffer[pos] = out[i]*inputGain + filtered*feedback
+			wet[i] += delayed
+		}
+	}
+	for _, delay := range allpassDelays {
+		buffer := make([]float64, max(1, int(float64(delay)*scale*roomScale)))
+		for i := range wet {
+			pos := i % len(buffer)
+			delayed := buffer[pos]
+			buffer[pos] = wet[i] + delayed*0.5
+			wet[i] = delayed - wet[i]
+		}
+	}
+	mix := clamp(e.Mix, 0, 1)
+	for i := range out {
+		out[i] = out[i]*(1-mix) + wet[i]*mix/float64(len(combDelays))
+	}
+	return out
+}
+
+// renderEffected returns the sound of the settings with the effects, at the given sample rate.
+// Both the processed sound and the resampled sound are kept in the render cache. The returned samples must not be modified.
+func renderEffected(cfg *synth.Settings, effects padEffects, sampleRate int) ([]float64, error) {
+	if !effects.enabled() {
+		return renders.resampled(cfg, sampleRate)
+	}
+	name := "effects " + effects.key()
+	processed, err := renders.analyze(cfg, name, func(samples []float64) []float64 {
+		return effects.apply(samples, cfg.SampleRate)
+	})
+	if err != nil || cfg.SampleRate == sampleRate {
+		return processed, err
+	}
+	return renders.analyze(cfg, fmt.Sprintf("%s resampled %d", name, sampleRate), func([]float64) []float64 {
+		return synth.Resample(processed, cfg.SampleRate, sampleRate)
+	})
+}
+
+// effectSlider is a slider for a parameter of an effect of the active pad
+func effectSlider(name string, value *float64, min, max float32) g.Widget {
+	v := float32(*value)
+	padIndex := activePadIndex
+	return g.Row(
+		g.Label(name),
+		g.SliderFloat(&v, min, max).Size(150).OnChange(func() {
+			recordSliderEdit(name, padIndex)
+			*value = float64(v)
+		}),
+	)
+}
+
+func effectSliderInt(name string, value *int, min, max int32) g.Widget {
+	v := int32(*value)
+	padIndex := activePadIndex
+	return g.Row(
+		g.Label(name),
+		g.SliderInt(&v, min, max).Size(150).OnChange(func() {
+			recordSliderEdit(name, padIndex)
+			*value = int(v)
+		}),
+	)
+}
+
+// effectNode is a collapsible section for one effect, with a checkbox that turns it on or off
+func effectNode(name string, enabled *bool, sliders ...g.Widget) g.Widget {
+	on := *enabled
+	padIndex := activePadIndex
+	return g.TreeNode(name).Layout(
+		append([]g.Widget{
+			g.Checkbox(fmt.Sprintf("On##%s", name), &on).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: %s", padLabel(padIndex), name), padIndex)
+				*enabled = on
+			}),
+		}, sliders...)...,
+	)
+}
+
+// createEffectsWidget shows the effects of the active pad
+func createEffectsWidget() g.Widget {
+	e := &padOpts[activePadIndex].Effects
+	return g.Child().Size(g.Auto, 120).Layout(
+		effectNode("EQ", &e.EQ.Enabled,
+			effectSlider("Low (dB)", &e.EQ.LowGain, -18, 18),
+			effectSlider("Mid frequency", &e.EQ.MidFrequency, 100, 10000),
+			effectSlider("Mid (dB)", &e.EQ.MidGain, -18, 18),
+			effectSlider("Mid Q", &e.EQ.MidQ, 0.3, 8),
+			effectSlider("High (dB)", &e.EQ.HighGain, -18, 18),
+		),
+		effectNode("Compressor", &e.Compressor.Enabled,
+			effectSlider("Threshold (dB)", &e.Compressor.Threshold, -60, 0),
+			effectSlider("Ratio", &e.Compressor.Ratio, 1, 20),
+			effectSlider("Attack (ms)", &e.Compressor.Attack, 0.1, 100),
+			effectSlider("Release (ms)", &e.Compressor.Release, 10, 1000),
+			effectSlider("Makeup (dB)", &e.Compressor.Makeup, 0, 24),
+		),
+		effectNode("Transient shaper", &e.Transient.Enabled,
+			effectSlider("Transient attack", &e.Transient.Attack, -1, 1),
+			effectSlider("Transient sustain", &e.Transient.Sustain, -1, 1),
+		),
+		effectNode("Saturation", &e.Saturation.Enabled,
+			effectSlider("Saturation drive", &e.Saturation.Drive, 0, 1),
+			effectSliderInt("Bits (0 is off)", &e.Saturation.Bits, 0, 16),
+			effectSliderInt("Downsample", &e.Saturation.Downsample, 1, 16),
+		),
+		effectNode("Room reverb", &e.Reverb.Enabled,
+			effectSlider("Reverb mix", &e.Reverb.Mix, 0, 1),
+			effectSlider("Room size", &e.Reverb.Size, 0, 1),
+			effectSlider("Damping", &e.Reverb.Damping, 0, 1),
+		),
+	)
+}
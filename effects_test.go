@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestCompressorThreshold(t *testing.T) {
+	samples := make([]float64, 4410)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(float64(i)*0.1) // a peak of -6 dB
+	}
+	peak := func(samples []float64) float64 {
+		p := 0.0
+		for _, sample := range samples[len(samples)/2:] {
+			p = math.Max(p, math.Abs(sample))
+		}
+		return p
+	}
+	e := defaultEffects()
+	e.Compressor.Enabled = true
+	e.Compressor.Threshold = 0
+	if got := peak(e.apply(samples, 44100)); math.Abs(got-0.5) > 0.001 {
+		t.Fatalf("a threshold of 0 dB compressed a peak of -6 dB to %f", got)
+	}
+	e.Compressor.Threshold = -18
+	if got := peak(e.apply(samples, 44100)); got > 0.4 {
+		t.Fatalf("a threshold of -18 dB left a peak of %f", got)
+	}
+}
+
+func TestEffectsDefaults(t *testing.T) {
+	// a pad from a kit file that was saved before the effects were added
+	var old padEffects
+	old.setDefaults()
+	if old != defaultEffects() {
+		t.Fatalf("got %+v", old)
+	}
+	// a compressor that has been set up keeps its threshold of 0 dB
+	var set padEffects
+	if err := json.Unmarshal([]byte(`{"Compressor": {"Threshold": 0, "Ratio": 2, "Attack": 1, "Release": 50}}`), &set); err != nil {
+		t.Fatal(err)
+	}
+	set.setDefaults()
+	if set.Compressor.Threshold != 0 || set.Compressor.Ratio != 2 {
+		t.Fatalf("got %+v", set.Compressor)
+	}
+}
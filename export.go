@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	exportPopup            = "Export"
+	defaultExportTemplate  = "{kit}_{pad:02}_{type}_{rate}k"
+	defaultExportDirectory = "."
+)
+
+var (
+	kitName         = "kickpad"
+	exportDirectory = defaultExportDirectory
+	exportTemplate  = defaultExportTemplate
+	exportOverwrite bool
+	exportSelected  [numPads]bool
+	exportErrors    []string
+
+	templateField = regexp.MustCompile(`\{([a-z]+)(?::([0-9]+))?\}`)
+)
+
+// expandTemplate replaces the {kit}, {pad}, {label}, {type}, {rate} and {bits} fields in a filename template.
+// Numeric fields can be zero padded by giving a width, like {pad:02}.
+func expandTemplate(template string, padIndex int) (string, error) {
+	cfg := pads[padIndex]
+	var err error
+	fileName := templateField.ReplaceAllStringFunc(template, func(field string) string {
+		m := templateField.FindStringSubmatch(field)
+		name, width := m[1], m[2]
+		var value string
+		switch name {
+		case "kit":
+			value = kitName
+		case "pad":
+			value = strconv.Itoa(padIndex + 1)
+		case "label":
+			value = strings.ReplaceAll(padLabel(padIndex), " ", "")
+		case "type":
+			value = cfg.SoundType.String()
+		case "rate":
+			value = strconv.FormatFloat(float64(cfg.SampleRate)/1000, 'f', -1, 64)
+		case "bits":
+			value = strconv.Itoa(cfg.BitDepth)
+		default:
+			err = fmt.Errorf("unknown field %s in filename template", field)
+			return field
+		}
+		if w, convErr := strconv.Atoi(width); convErr == nil && len(value) < w {
+			value = strings.Repeat("0", w-len(value)) + value
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	if fileName == "" || strings.ContainsAny(fileName, `/\`) {
+		return "", fmt.Errorf("invalid filename %q", fileName)
+	}
+	if !hasAudioExtension(fileName) {
+		fileName += exportExtensions[exportFormatIndex]
+	}
+	return fileName, nil
+}
+
+// exportGain returns the gain that normalizes the rendered samples of a pad with the export settings
+func exportGain(samples []float64, cfg *synth.Settings) float64 {
+	return normalizationGain(samples, cfg.SampleRate, cfg.Channels, int(exportNormalize), normalizationTarget())
+}
+
+// finishExport returns a copy of the rendered samples of a pad as they are written, with the given gain from exportGain,
+// and dithered to the bit depth of the pad
+func finishExport(samples []float64, cfg *synth.Settings, gain float64, seed int64) []float64 {
+	scaled := make([]float64, len(samples))
+	for i, sample := range samples {
+		scaled[i] = sample * gain
+	}
+	return dither(scaled, cfg.BitDepth, cfg.Channels, int(exportDither), seed)
+}
+
+func exportPad(padIndex int, fileName string) error {
+	if !exportOverwrite {
+		if _, err := os.Stat(fileName); err == nil {
+			return fmt.Errorf("%s already exists", fileName)
+		}
+	}
+	cfg := pads[padIndex]
+	samples, err := padOpts[padIndex].renderChannels(cfg, padOpts[padIndex].sound(), cfg.SampleRate)
+	if err != nil {
+		return err
+	}
+	samples = finishExport(samples, cfg, exportGain(samples, cfg), padDitherSeed(padIndex, 0))
+	if err := writeAudioFile(fileName, samples, cfg.SampleRate, cfg.BitDepth, cfg.Channels); err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(fileName)) != ".wav" {
+		return nil
+	}
+	return embedSettings(fileName, cfg, padOpts[padIndex])
+}
+
+// exportPads renders the given pads to the export directory, using the filename template.
+// It tries to export every pad, and returns the filenames that were written together with one error per failed pad.
+func exportPads(padIndices []int) ([]string, []error) {
+	var (
+		written []string
+		errs    []error
+		seen    = make(map[string]int)
+	)
+	if err := os.MkdirAll(exportDirectory, 0o755); err != nil {
+		return nil, []error{err}
+	}
+	for _, padIndex := range padIndices {
+		fileName, err := expandTemplate(exportTemplate, padIndex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", padLabel(padIndex), err))
+			continue
+		}
+		fileName = filepath.Join(exportDirectory, fileName)
+		if other, ok := seen[fileName]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s is also used by %s", padLabel(padIndex), fileName, padLabel(other)))
+			continue
+		}
+		seen[fileName] = padIndex
+		if err := exportPad(padIndex, fileName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", padLabel(padIndex), err))
+			continue
+		}
+		written = append(written, fileName)
+	}
+	return written, errs
+}
+
+func exportAndReport(padIndices []int) {
+	written, errs := exportPads(padIndices)
+	exportErrors = exportErrors[:0]
+	for _, err := range errs {
+		exportErrors = append(exportErrors, err.Error())
+	}
+	switch {
+	case len(errs) == 0 && len(written) == 1:
+		setStatusMessage(fmt.Sprintf("%s saved to %s", pads[padIndices[0]].SoundType, written[0]))
+	case len(errs) == 0:
+		setStatusMessage(fmt.Sprintf("Exported %d pads to %s", len(written), exportDirectory))
+	case len(errs) == 1:
+		setStatusMessage(fmt.Sprintf("Error: Failed to export %v", errs[0]))
+	default:
+		setStatusMessage(fmt.Sprintf("Error: Failed to export %d of %d pads, see File > Export... for details", len(errs), len(padIndices)))
+	}
+}
+
+func selectedPads() []int {
+	var padIndices []int
+	for i, selected := range exportSelected {
+		if selected {
+			padIndices = append(padIndices, i)
+		}
+	}
+	return padIndices
+}
+
+func exportPopupWidget() g.Widget {
+	var selectionRows []g.Widget
+	for row := 0; row < 4; row++ {
+		var checkboxes []g.Widget
+		for col := 0; col < 4; col++ {
+			padIndex := row*4 + col
+			checkboxes = append(checkboxes, g.Checkbox(fmt.Sprintf("%2d", padIndex+1), &exportSelected[padIndex]))
+		}
+		selectionRows = append(selectionRows, g.Row(checkboxes...))
+	}
+	preview, err := expandTemplate(exportTemplate, activePadIndex)
+	if err != nil {
+		preview = err.Error()
+	}
+	var errorLabels []g.Widget
+	for _, msg := range exportErrors {
+		errorLabels = append(errorLabels, g.Label(msg))
+	}
+	return g.PopupModal(exportPopup).Layout(
+		g.Row(
+			g.Label("Kit name"),
+			g.InputText(&kitName).Size(250),
+		),
+		g.Row(
+			g.Label("Directory"),
+			g.InputText(&exportDirectory).Size(250),
+		),
+		g.Row(
+			g.Label("Filename"),
+			g.InputText(&exportTemplate).Size(250),
+		),
+		g.Row(
+			g.Label("Format"),
+			g.Combo("##exportFormat", exportFormats[exportFormatIndex], exportFormats, &exportFormatIndex).Size(100),
+			g.Label("(unless the filename ends with .wav, .flac or .aiff)"),
+		),
+		g.Label(fmt.Sprintf("%s: %s", padLabel(activePadIndex), preview)),
+		createNormalizeWidget(),
+		createDitherWidget(),
+		g.Checkbox("Overwrite existing files", &exportOverwrite),
+		g.Dummy(30, 0),
+		g.Label("Pads to export:"),
+		g.Column(selectionRows...),
+		g.Row(
+			g.Button("All").OnClick(func() {
+				for i := range exportSelected {
+					exportSelected[i] = true
+				}
+			}),
+			g.Button("None").OnClick(func() {
+				for i := range exportSelected {
+					exportSelected[i] = false
+				}
+			}),
+		),
+		g.Dummy(30, 0),
+		g.Column(errorLabels...),
+		g.Row(
+			g.Button("Export selected pads").OnClick(func() {
+				padIndices := selectedPads()
+				if len(padIndices) == 0 {
+					exportErrors = []string{"No pads are selected"}
+					return
+				}
+				exportAndReport(padIndices)
+				if len(exportErrors) == 0 {
+					g.CloseCurrentPopup()
+				}
+			}),
+			g.Button("Close").OnClick(func() {
+				exportErrors = nil
+				g.CloseCurrentPopup()
+			}),
+		),
+	)
+}
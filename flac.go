@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const flacBlockSize = 4096
+
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if r.pos+n > len(r.data)*8 {
+		return 0, errors.New("unexpected end of FLAC data")
+	}
+	var value uint64
+	for i := 0; i < n; i++ {
+		bit := (r.data[r.pos>>3] >> (7 - uint(r.pos&7))) & 1
+		value = value<<1 | uint64(bit)
+		r.pos++
+	}
+	return value, nil
+}
+
+func (r *bitReader) readSigned(n int) (int64, error) {
+	value, err := r.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(value<<(64-n)) >> (64 - n), nil
+}
+
+func (r *bitReader) readUnary() (uint64, error) {
+	var count uint64
+	for {
+		bit, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return count, nil
+		}
+		count++
+	}
+}
+
+func (r *bitReader) alignToByte() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+type bitWriter struct {
+	buf   []byte
+	cache uint64
+	bits  int
+}
+
+func (w *bitWriter) writeBits(value uint64, n int) {
+	for n > 0 {
+		take := n
+		if take > 32 {
+			take = 32
+		}
+		n -= take
+		w.cache = w.cache<<take | (value>>n)&(1<<take-1)
+		w.bits += take
+		for w.bits >= 8 {
+			w.bits -= 8
+			w.buf = append(w.buf, byte(w.cache>>w.bits))
+		}
+	}
+}
+
+func (w *bitWriter) writeUnary(count uint64) {
+	for ; count >= 32; count -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, int(count)+1)
+}
+
+func (w *bitWriter) alignToByte() {
+	if w.bits > 0 {
+		w.writeBits(0, 8-w.bits)
+	}
+}
+
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// decodeFlac decodes a FLAC stream with fixed or LPC subframes, as written by any FLAC encoder
+func decodeFlac(data []byte) (*audioData, error) {
+	var audio audioData
+	pos := 4
+	for last := false; !last; {
+		if pos+4 > len(data) {
+			return nil, errors.New("invalid FLAC metadata")
+		}
+		last = data[pos]&0x80 != 0
+		blockType := data[pos] & 0x7f
+		length := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		pos += 4
+		if pos+length > len(data) {
+			return nil, errors.New("invalid FLAC metadata")
+		}
+		if blockType == 0 && length >= 18 {
+			r := &bitReader{data: data[pos : pos+length]}
+			r.readBits(16 + 16 + 24 + 24)
+			sampleRate, _ := r.readBits(20)
+			channels, _ := r.readBits(3)
+			bitDepth, _ := r.readBits(5)
+			audio.SampleRate = int(sampleRate)
+			audio.Channels = int(channels) + 1
+			audio.BitDepth = int(bitDepth) + 1
+		}
+		pos += length
+	}
+	if audio.SampleRate == 0 {
+		return nil, errors.New("the FLAC file has no STREAMINFO block")
+	}
+	r := &bitReader{data: data, pos: pos * 8}
+	var ints []int64
+	for r.pos+16 <= len(data)*8 {
+		frame, err := decodeFlacFrame(r, &audio)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, frame...)
+	}
+	scale := float64(int64(1) << (audio.BitDepth - 1))
+	audio.Samples = make([]float64, len(ints))
+	for i, value := range ints {
+		audio.Samples[i] = float64(value) / scale
+	}
+	return &audio, nil
+}
+
+// decodeFlacFrame decodes one frame and returns the interleaved samples
+func decodeFlacFrame(r *bitReader, audio *audioData) ([]int64, error) {
+	sync, _ := r.readBits(14)
+	if sync != 0x3ffe {
+		return nil, errors.New("lost FLAC frame sync")
+	}
+	r.readBits(2) // reserved bit and blocking strategy
+	blockSizeCode, _ := r.readBits(4)
+	sampleRateCode, _ := r.readBits(4)
+	channelAssignment, _ := r.readBits(4)
+	sampleSizeCode, _ := r.readBits(3)
+	r.readBits(1)
+	// The frame or sample number is UTF-8 coded
+	first, err := r.readBits(8)
+	if err != nil {
+		return nil, err
+	}
+	for mask := uint64(0x80); first&mask != 0 && mask > 1; mask >>= 1 {
+		if mask != 0x80 {
+			r.readBits(8)
+		}
+	}
+	blockSize := 0
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, _ := r.readBits(8)
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, _ := r.readBits(16)
+		blockSize = int(v) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return nil, errors.New("invalid FLAC block size")
+	}
+	switch sampleRateCode {
+	case 12:
+		r.readBits(8)
+	case 13, 14:
+		r.readBits(16)
+	}
+	r.readBits(8) // CRC-8 of the frame header
+	bitDepth := audio.BitDepth
+	if bits := []int{0, 8, 12, 0, 16, 20, 24, 32}[sampleSizeCode]; bits != 0 {
+		bitDepth = bits
+	}
+	channels := int(channelAssignment) + 1
+	if channelAssignment >= 8 {
+		if channelAssignment > 10 {
+			return nil, errors.New("invalid FLAC channel assignment")
+		}
+		channels = 2
+	}
+	if channels != audio.Channels {
+		return nil, errors.New("the number of channels changes within the FLAC stream")
+	}
+	subframes := make([][]int64, channels)
+	for c := range subframes {
+		subframeBitDepth := bitDepth
+		// The side channel has one extra bit
+		if (channelAssignment == 8 && c == 1) || (channelAssignment == 9 && c == 0) || (channelAssignment == 10 && c == 1) {
+			subframeBitDepth++
+		}
+		subframes[c], err = decodeFlacSubframe(r, blockSize, subframeBitDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.alignToByte()
+	r.readBits(16) // CRC-16 of the frame
+	switch channelAssignment {
+	case 8: // left/side
+		for i := range subframes[1] {
+			subframes[1][i] = subframes[0][i] - subframes[1][i]
+		}
+	case 9: // side/right
+		for i := range subframes[0] {
+			subframes[0][i] += subframes[1][i]
+		}
+	case 10: // mid/side
+		for i := range subframes[0] {
+			mid, side := subframes[0][i]<<1|subframes[1][i]&1, subframes[1][i]
+			subframes[0][i] = (mid + side) >> 1
+			subframes[1][i] = (mid - side) >> 1
+		}
+	}
+	interleaved := make([]int64, blockSize*channels)
+	for c, subframe := range subframes {
+		for i, value := range subframe {
+			interleaved[i*channels+c] = value
+		}
+	}
+	return interleaved, nil
+}
+
+func decodeFlacSubframe(r *bitReader, blockSize, bitDepth int) ([]int64, error) {
+	header, err := r.readBits(8)
+	if err != nil {
+		return nil, err
+	}
+	subframeType := int(header>>1) & 0x3f
+	wasted := 0
+	if header&1 == 1 {
+		k, err := r.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(k) + 1
+		bitDepth -= wasted
+	}
+	if bitDepth <= 0 {
+		return nil, errors.New("invalid FLAC subframe")
+	}
+	samples := make([]int64, blockSize)
+	switch {
+	case subframeType == 0: // constant
+		value, err := r.readSigned(bitDepth)
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = value
+		}
+	case subframeType == 1: // verbatim
+		for i := range samples {
+			if samples[i], err = r.readSigned(bitDepth); err != nil {
+				return nil, err
+			}
+		}
+	case subframeType >= 8 && subframeType <= 12: // fixed
+		order := subframeType - 8
+		if order > blockSize {
+			return nil, errors.New("invalid FLAC subframe")
+		}
+		for i := 0; i < order; i++ {
+			if samples[i], err = r.readSigned(bitDepth); err != nil {
+				return nil, err
+			}
+		}
+		if err := decodeFlacResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < blockSize; i++ {
+			switch order {
+			case 1:
+				samples[i] += samples[i-1]
+			case 2:
+				samples[i] += 2*samples[i-1] - samples[i-2]
+			case 3:
+				samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+			case 4:
+				samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+			}
+		}
+	case subframeType >= 32: // LPC
+		order := subframeType - 31
+		if order > blockSize {
+			return nil, errors.New("invalid FLAC subframe")
+		}
+		for i := 0; i < order; i++ {
+			if samples[i], err = r.readSigned(bitDepth); err != nil {
+				return nil, err
+			}
+		}
+		precision, _ := r.readBits(4)
+		if precision == 15 {
+			return nil, errors.New("invalid FLAC LPC precision")
+		}
+		shift, _ := r.readSigned(5)
+		if shift < 0 {
+			return nil, errors.New("invalid FLAC LPC shift")
+		}
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			if coefficients[i], err = r.readSigned(int(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := decodeFlacResidual(r, samples, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < blockSize; i++ {
+			var prediction int64
+			for j, coefficient := range coefficients {
+				prediction += coefficient * samples[i-j-1]
+			}
+			samples[i] += prediction >> uint(shift)
+		}
+	default:
+		return nil, fmt.Errorf("invalid FLAC subframe type: %d", subframeType)
+	}
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= uint(wasted)
+		}
+	}
+	return samples, nil
+}
+
+// decodeFlacResidual reads the Rice coded residual into samples[order:]
+func decodeFlacResidual(r *bitReader, samples []int64, order int) error {
+	method, _ := r.readBits(2)
+	if method > 1 {
+		return errors.New("invalid FLAC residual coding method")
+	}
+	paramBits, escape := 4, uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partitionOrder, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	i := order
+	for p := 0; p < partitions; p++ {
+		count := partitionSize
+		if p == 0 {
+			count -= order
+		}
+		if count < 0 || i+count > len(samples) {
+			return errors.New("invalid FLAC residual partition")
+		}
+		param, err := r.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			bits, _ := r.readBits(5)
+			for end := i + count; i < end; i++ {
+				if samples[i], err = r.readSigned(int(bits)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for end := i + count; i < end; i++ {
+			quotient, err := r.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := r.readBits(int(param))
+			if err != nil {
+				return err
+			}
+			u := quotient<<param | low
+			samples[i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+	return nil
+}
+
+// writeFlac encodes the samples as FLAC, using the fixed predictor that gives the smallest residual for each subframe
+func writeFlac(filePath string, samples []float64, sampleRate, bitDepth, channels int) error {
+	if err := checkEncodable(samples, bitDepth, channels, 8, 16, 24); err != nil {
+		return err
+	}
+	if channels > 8 {
+		return fmt.Errorf("FLAC supports up to 8 channels, not %d", channels)
+	}
+	ints := quantize(samples, bitDepth)
+	frames := len(ints) / channels
+	blockSize := flacBlockSize
+	if frames < blockSize {
+		blockSize = frames
+	}
+
+	hash := md5.New()
+	bytesPerSample := bitDepth / 8
+	sampleBytes := make([]byte, 4)
+	for _, value := range ints {
+		binary.LittleEndian.PutUint32(sampleBytes, uint32(value))
+		hash.Write(sampleBytes[:bytesPerSample])
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	info := &bitWriter{}
+	info.writeBits(1, 1) // last metadata block
+	info.writeBits(0, 7) // STREAMINFO
+	info.writeBits(34, 24)
+	info.writeBits(uint64(blockSize), 16)
+	info.writeBits(uint64(blockSize), 16)
+	info.writeBits(0, 24) // unknown minimum frame size
+	info.writeBits(0, 24) // unknown maximum frame size
+	info.writeBits(uint64(sampleRate), 20)
+	info.writeBits(uint64(channels-1), 3)
+	info.writeBits(uint64(bitDepth-1), 5)
+	info.writeBits(uint64(frames), 36)
+	buf.Write(info.buf)
+	buf.Write(hash.Sum(nil))
+
+	sampleSizeCode := map[int]uint64{8: 1, 16: 4, 24: 6}[bitDepth]
+	channel := make([]int64, blockSize)
+	for frameNumber, start := 0, 0; start < frames; frameNumber, start = frameNumber+1, start+blockSize {
+		size := blockSize
+		if start+size > frames {
+			size = frames - start
+		}
+		w := &bitWriter{}
+		w.writeBits(0x3ffe, 14)
+		w.writeBits(0, 2) // reserved bit and fixed block size
+		w.writeBits(7, 4) // the block size follows the header, as 16 bits
+		w.writeBits(0, 4) // the sample rate is in STREAMINFO
+		w.writeBits(uint64(channels-1), 4)
+		w.writeBits(sampleSizeCode, 3)
+		w.writeBits(0, 1)
+		writeUTF8Number(w, uint64(frameNumber))
+		w.writeBits(uint64(size-1), 16)
+		w.writeBits(uint64(flacCRC8(w.buf)), 8)
+		for c := 0; c < channels; c++ {
+			for i := 0; i < size; i++ {
+				channel[i] = ints[(start+i)*channels+c]
+			}
+			encodeFlacSubframe(w, channel[:size], bitDepth)
+		}
+		w.alignToByte()
+		w.writeBits(uint64(flacCRC16(w.buf)), 16)
+		buf.Write(w.buf)
+	}
+	return os.WriteFile(filePath, buf.Bytes(), 0o644)
+}
+
+func writeUTF8Number(w *bitWriter, n uint64) {
+	if n < 0x80 {
+		w.writeBits(n, 8)
+		return
+	}
+	// Count the number of continuation bytes
+	extra := 1
+	for n >= 1<<(6+5*extra) {
+		extra++
+	}
+	w.writeBits((0xff00>>(extra+1))&0xff|n>>(6*extra), 8)
+	for i := extra - 1; i >= 0; i-- {
+		w.writeBits(0x80|(n>>(6*i))&0x3f, 8)
+	}
+}
+
+func fixedResidual(samples []int64, order int) []int64 {
+	residual := make([]int64, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		var prediction int64
+		switch order {
+		case 1:
+			prediction = samples[i-1]
+		case 2:
+			prediction = 2*samples[i-1] - samples[i-2]
+		case 3:
+			prediction = 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			prediction = 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+		residual[i-order] = samples[i] - prediction
+	}
+	return residual
+}
+
+func encodeFlacSubframe(w *bitWriter, samples []int64, bitDepth int) {
+	bestOrder, bestSum := 0, int64(-1)
+	var bestResidual []int64
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		residual := fixedResidual(samples, order)
+		var sum int64
+		for _, value := range residual {
+			if value < 0 {
+				value = -value
+			}
+			sum += value
+		}
+		if bestSum < 0 || sum < bestSum {
+			bestOrder, bestSum, bestResidual = order, sum, residual
+		}
+	}
+	w.writeBits(uint64(8+bestOrder)<<1, 8) // fixed subframe, no wasted bits
+	for i := 0; i < bestOrder; i++ {
+		w.writeBits(uint64(samples[i]), bitDepth)
+	}
+	// Pick the Rice parameter from the mean of the zigzag encoded residual
+	param := 0
+	if len(bestResidual) > 0 {
+		for mean := uint64(2*bestSum) / uint64(len(bestResidual)); mean > 1 && param < 30; mean >>= 1 {
+			param++
+		}
+	}
+	if param > 14 {
+		w.writeBits(1, 2)
+		w.writeBits(0, 4) // partition order 0
+		w.writeBits(uint64(param), 5)
+	} else {
+		w.writeBits(0, 2)
+		w.writeBits(0, 4)
+		w.writeBits(uint64(param), 4)
+	}
+	for _, value := range bestResidual {
+		u := uint64(value<<1) ^ uint64(value>>63)
+		w.writeUnary(u >> param)
+		w.writeBits(u, param)
+	}
+}
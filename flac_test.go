@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeFlac writes the samples as FLAC, and returns the file
+func encodeFlac(t *testing.T, samples []float64, sampleRate, bitDepth, channels int) []byte {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "test.flac")
+	if err := writeFlac(filePath, samples, sampleRate, bitDepth, channels); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFlacRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sounds := map[string]func(i int) float64{
+		"sine":    func(i int) float64 { return 0.9 * math.Sin(float64(i)*0.03) },
+		"silence": func(int) float64 { return 0 },
+		"noise":   func(int) float64 { return r.Float64()*2 - 1 },
+		"clipped": func(i int) float64 { return 2 * math.Sin(float64(i)*0.001) },
+	}
+	for name, sound := range sounds {
+		for _, bitDepth := range []int{8, 16, 24} {
+			for _, channels := range []int{1, 2} {
+				// the length is not a multiple of the block size, so that the last frame is shorter
+				samples := make([]float64, channels*(2*flacBlockSize+123))
+				for i := range samples {
+					samples[i] = sound(i / channels)
+				}
+				audio, err := decodeAudio(encodeFlac(t, samples, 44100, bitDepth, channels))
+				if err != nil {
+					t.Fatalf("%s, %d-bit, %d channels: %v", name, bitDepth, channels, err)
+				}
+				if audio.SampleRate != 44100 || audio.Channels != channels || audio.BitDepth != bitDepth {
+					t.Fatalf("%s: got %d Hz, %d channels and %d-bit", name, audio.SampleRate, audio.Channels, audio.BitDepth)
+				}
+				if len(audio.Samples) != len(samples) {
+					t.Fatalf("%s, %d-bit, %d channels: got %d samples, not %d", name, bitDepth, channels, len(audio.Samples), len(samples))
+				}
+				scale := float64(int64(1) << (bitDepth - 1))
+				for i, value := range quantize(samples, bitDepth) {
+					if got := int64(math.Round(audio.Samples[i] * scale)); got != value {
+						t.Fatalf("%s, %d-bit, %d channels: sample %d is %d, not %d", name, bitDepth, channels, i, got, value)
+					}
+				}
+			}
+		}
+	}
+}
+
+// flacWithSubframe returns a mono 16-bit FLAC file with one frame of the given block size, followed by the subframe
+func flacWithSubframe(blockSize int, subframe func(w *bitWriter)) []byte {
+	info := &bitWriter{}
+	info.writeBits(1, 1) // last metadata block
+	info.writeBits(0, 7) // STREAMINFO
+	info.writeBits(34, 24)
+	info.writeBits(uint64(blockSize), 16)
+	info.writeBits(uint64(blockSize), 16)
+	info.writeBits(0, 48)
+	info.writeBits(44100, 20)
+	info.writeBits(0, 3)  // mono
+	info.writeBits(15, 5) // 16-bit
+	info.writeBits(uint64(blockSize), 36)
+	info.writeBits(0, 128) // no MD5
+
+	w := &bitWriter{}
+	w.writeBits(0x3ffe, 14)
+	w.writeBits(0, 2)
+	w.writeBits(6, 4) // the block size follows the header, as 8 bits
+	w.writeBits(0, 4)
+	w.writeBits(0, 4) // mono
+	w.writeBits(4, 3) // 16-bit
+	w.writeBits(0, 1)
+	w.writeBits(0, 8) // frame number
+	w.writeBits(uint64(blockSize-1), 8)
+	w.writeBits(uint64(flacCRC8(w.buf)), 8)
+	subframe(w)
+	// enough zeros for the decoder to read past the subframe
+	w.writeBits(0, 64)
+	w.writeBits(0, 64)
+	w.alignToByte()
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	buf.Write(info.buf)
+	buf.Write(w.buf)
+	return buf.Bytes()
+}
+
+func TestFlacMalformedSubframes(t *testing.T) {
+	subframes := map[string]func(w *bitWriter){
+		"fixed order 4 in a block of 1": func(w *bitWriter) {
+			w.writeBits(12<<1, 8)
+		},
+		"LPC order 32 in a block of 1": func(w *bitWriter) {
+			w.writeBits(63<<1, 8)
+		},
+		"more wasted bits than the bit depth": func(w *bitWriter) {
+			w.writeBits(1, 8)  // constant, with wasted bits
+			w.writeBits(0, 20) // 21 wasted bits, coded as unary
+			w.writeBits(1, 1)
+		},
+	}
+	for name, subframe := range subframes {
+		if _, err := decodeAudio(flacWithSubframe(1, subframe)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestFlacCorrupted(t *testing.T) {
+	samples := make([]float64, 2*300)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(float64(i)*0.05)
+	}
+	data := encodeFlac(t, samples, 44100, 16, 2)
+	// every truncated or damaged file either decodes or returns an error, without panicking
+	for length := 4; length < len(data); length++ {
+		decodeAudio(data[:length])
+	}
+	for i := 4; i < len(data); i++ {
+		for _, b := range []byte{0x00, 0xff, data[i] ^ 0x5a} {
+			damaged := append([]byte(nil), data...)
+			damaged[i] = b
+			decodeAudio(damaged)
+		}
+	}
+}
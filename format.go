@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+// padFormat is the sample rate, bit depth and number of channels that a pad is played and exported with.
+// It is kept in the settings of the pad, so that it is stored together with them.
+type padFormat struct {
+	SampleRate int
+	BitDepth   int
+	Channels   int
+}
+
+var (
+	defaultFormat = padFormat{SampleRate: sampleRates[0], BitDepth: defaultBitDepth, Channels: 1}
+	channelNames  = []string{"Mono", "Stereo"}
+)
+
+func formatOf(cfg *synth.Settings) padFormat {
+	return padFormat{SampleRate: cfg.SampleRate, BitDepth: cfg.BitDepth, Channels: cfg.Channels}
+}
+
+func (f padFormat) applyTo(cfg *synth.Settings) {
+	cfg.SampleRate, cfg.BitDepth, cfg.Channels = f.SampleRate, f.BitDepth, f.Channels
+}
+
+// newRandom returns random settings of the given sound type, in this format
+func (f padFormat) newRandom(soundType synth.SoundType) *synth.Settings {
+	return synth.NewRandom(soundType, nil, f.SampleRate, f.BitDepth, f.Channels)
+}
+
+func (f padFormat) String() string {
+	return fmt.Sprintf("%d Hz, %d-bit, %s", f.SampleRate, f.BitDepth, channelNames[min(f.Channels, 2)-1])
+}
+
+// setPadFormat changes the format of the given pads, and of the layers of the pads
+func setPadFormat(format padFormat, padIndices ...int) {
+	for _, padIndex := range padIndices {
+		format.applyTo(pads[padIndex])
+		for _, layer := range padOpts[padIndex].Layers {
+			format.applyTo(layer.Settings)
+		}
+	}
+}
+
+// createFormatWidget shows the format of the active pad
+func createFormatWidget() g.Widget {
+	format := formatOf(pads[activePadIndex])
+	var rateNames []string
+	rateIndex := int32(0)
+	for i, rate := range sampleRates {
+		rateNames = append(rateNames, fmt.Sprintf("%d Hz", rate))
+		if rate == format.SampleRate {
+			rateIndex = int32(i)
+		}
+	}
+	deep := format.BitDepth == 24
+	channelIndex := int32(min(max(format.Channels, 1), 2) - 1)
+	change := func(name string, f padFormat) {
+		recordEdit(fmt.Sprintf("%s: %s", padLabel(activePadIndex), name), activePadIndex)
+		setPadFormat(f, activePadIndex)
+	}
+	return g.Column(
+		g.Row(
+			g.Label("Sample Rate"),
+			g.Combo("Sample Rate", rateNames[rateIndex], rateNames, &rateIndex).Size(150).OnChange(func() {
+				format.SampleRate = sampleRates[rateIndex]
+				change("Sample Rate", format)
+			}),
+		),
+		g.Row(
+			g.Label("Bit Depth"),
+			g.Checkbox("24-bit instead of 16-bit", &deep).OnChange(func() {
+				format.BitDepth = 16
+				if deep {
+					format.BitDepth = 24
+				}
+				change("Bit Depth", format)
+			}),
+		),
+		g.Row(
+			g.Label("Channels"),
+			g.Combo("Channels", channelNames[channelIndex], channelNames, &channelIndex).Size(150).OnChange(func() {
+				format.Channels = int(channelIndex) + 1
+				change("Channels", format)
+			}),
+		),
+		g.Button("Apply format to all pads").OnClick(func() {
+			recordEdit(fmt.Sprintf("Format %s", format), allPadIndices()...)
+			setPadFormat(format, allPadIndices()...)
+			setStatusMessage(fmt.Sprintf("All pads are %s", format))
+		}),
+	)
+}
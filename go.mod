@@ -3,15 +3,16 @@ module github.com/xyproto/kickpad
 go 1.23.1
 
 require (
+	github.com/AllenDang/cimgui-go v1.0.1
 	github.com/AllenDang/giu v0.8.2-0.20240925160912-ed0cb9e7048c
 	github.com/go-audio/wav v1.1.0
 	github.com/mjibson/go-dsp v0.0.0-20180508042940-11479a337f12
+	github.com/veandco/go-sdl2 v0.4.40
 	github.com/xyproto/playsample v0.2.1
 	github.com/xyproto/synth v1.14.0
 )
 
 require (
-	github.com/AllenDang/cimgui-go v1.0.1 // indirect
 	github.com/AllenDang/go-findfont v0.0.0-20200702051237-9f180485aeb8 // indirect
 	github.com/faiface/mainthread v0.0.0-20171120011319-8b78f0a41ae3 // indirect
 	github.com/go-audio/audio v1.0.0 // indirect
@@ -20,7 +21,6 @@ require (
 	github.com/napsy/go-css v0.0.0-20230611142900-9dd118f3874c // indirect
 	github.com/pkg/browser v0.0.0-20240102092130-5ac0b6a4141c // indirect
 	github.com/sahilm/fuzzy v0.1.1 // indirect
-	github.com/veandco/go-sdl2 v0.4.40 // indirect
 	github.com/xyproto/audioeffects v0.11.1 // indirect
 	github.com/xyproto/binary v1.3.3 // indirect
 	github.com/xyproto/env/v2 v2.5.3 // indirect
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	maxHistory     = 100
+	coalesceWindow = time.Second
+)
+
+type padSnapshot struct {
+	padIndex int
+	settings *synth.Settings
+	opts     padOptions
+}
+
+type historyEntry struct {
+	description string
+	snapshots   []padSnapshot
+	coalesceKey string
+	changed     time.Time
+}
+
+var (
+	undoStack []historyEntry
+	redoStack []historyEntry
+)
+
+func snapshotPads(padIndices []int) []padSnapshot {
+	snapshots := make([]padSnapshot, 0, len(padIndices))
+	for _, padIndex := range padIndices {
+		snapshots = append(snapshots, padSnapshot{
+			padIndex: padIndex,
+			settings: synth.CopySettings(pads[padIndex]),
+			opts:     padOpts[padIndex].clone(),
+		})
+	}
+	return snapshots
+}
+
+func (snapshot padSnapshot) restore() {
+	pads[snapshot.padIndex] = synth.CopySettings(snapshot.settings)
+	padOpts[snapshot.padIndex] = snapshot.opts.clone()
+	roundRobinIndex[snapshot.padIndex] = 0
+}
+
+func allPadIndices() []int {
+	padIndices := make([]int, numPads)
+	for i := range padIndices {
+		padIndices[i] = i
+	}
+	return padIndices
+}
+
+// recordEdit stores the current state of the given pads, and should be called right before they are changed
+func recordEdit(description string, padIndices ...int) {
+	pushHistory(historyEntry{description: description, snapshots: snapshotPads(padIndices)})
+}
+
+// recordSliderEdit is like recordEdit, but a drag of the same slider only results in one entry
+func recordSliderEdit(parameter string, padIndex int) {
+	key := fmt.Sprintf("%d %s", padIndex, parameter)
+	now := time.Now()
+	if n := len(undoStack); n > 0 && undoStack[n-1].coalesceKey == key && now.Sub(undoStack[n-1].changed) < coalesceWindow {
+		undoStack[n-1].changed = now
+		return
+	}
+	pushHistory(historyEntry{
+		description: fmt.Sprintf("%s: %s", padLabel(padIndex), parameter),
+		snapshots:   snapshotPads([]int{padIndex}),
+		coalesceKey: key,
+		changed:     now,
+	})
+}
+
+// pushHistory adds an entry to the undo stack. Every change to a pad is recorded here, so this is also where pads are marked as in use.
+func pushHistory(entry historyEntry) {
+	for _, snapshot := range entry.snapshots {
+		padInUse[snapshot.padIndex] = true
+	}
+	undoStack = append(undoStack, entry)
+	if len(undoStack) > maxHistory {
+		undoStack = undoStack[len(undoStack)-maxHistory:]
+	}
+	redoStack = redoStack[:0]
+}
+
+// swapHistory restores the last entry of one stack, and pushes the state it replaced to the other stack
+func swapHistory(from, to *[]historyEntry) (string, bool) {
+	n := len(*from)
+	if n == 0 {
+		return "", false
+	}
+	entry := (*from)[n-1]
+	*from = (*from)[:n-1]
+	padIndices := make([]int, len(entry.snapshots))
+	for i, snapshot := range entry.snapshots {
+		padIndices[i] = snapshot.padIndex
+	}
+	*to = append(*to, historyEntry{description: entry.description, snapshots: snapshotPads(padIndices)})
+	for _, snapshot := range entry.snapshots {
+		snapshot.restore()
+	}
+	return entry.description, true
+}
+
+func undo() {
+	if atomic.LoadInt32(&trainingOngoing) == 1 {
+		setStatusMessage("Stop the training before undoing")
+		return
+	}
+	if description, ok := swapHistory(&undoStack, &redoStack); ok {
+		setStatusMessage("Undo " + description)
+	}
+}
+
+func redo() {
+	if atomic.LoadInt32(&trainingOngoing) == 1 {
+		setStatusMessage("Stop the training before redoing")
+		return
+	}
+	if description, ok := swapHistory(&redoStack, &undoStack); ok {
+		setStatusMessage("Redo " + description)
+	}
+}
+
+func createHistoryWidget() g.Widget {
+	var entries []g.Widget
+	for i := len(redoStack) - 1; i >= 0; i-- {
+		entries = append(entries, g.Style().SetDisabled(true).To(g.Label("  "+redoStack[i].description)))
+	}
+	for i := len(undoStack) - 1; i >= 0; i-- {
+		prefix := "  "
+		if i == len(undoStack)-1 {
+			prefix = "> "
+		}
+		entries = append(entries, g.Label(prefix+undoStack[i].description))
+	}
+	return g.Column(
+		g.Row(
+			g.Button("Undo").Disabled(len(undoStack) == 0).OnClick(undo),
+			g.Button("Redo").Disabled(len(redoStack) == 0).OnClick(redo),
+			g.Label("Ctrl+Z / Ctrl+Shift+Z"),
+		),
+		g.Child().Size(-1, 250).Layout(entries...),
+	)
+}
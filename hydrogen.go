@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	hydrogenNamespace   = "http://www.hydrogen-music.org/drumkit"
+	hydrogenDrumkitFile = "drumkit.xml"
+	hydrogenPopup       = "Hydrogen drumkit"
+)
+
+type hydrogenDrumkit struct {
+	XMLName     xml.Name                   `xml:"drumkit_info"`
+	Namespace   string                     `xml:"xmlns,attr,omitempty"`
+	Name        string                     `xml:"name"`
+	Author      string                     `xml:"author"`
+	Info        string                     `xml:"info"`
+	License     string                     `xml:"license"`
+	Components  []hydrogenDrumkitComponent `xml:"componentList>drumkitComponent"`
+	Instruments []hydrogenInstrument       `xml:"instrumentList>instrument"`
+}
+
+type hydrogenDrumkitComponent struct {
+	ID     int     `xml:"id"`
+	Name   string  `xml:"name"`
+	Volume float64 `xml:"volume"`
+}
+
+type hydrogenInstrument struct {
+	ID          int                           `xml:"id"`
+	Name        string                        `xml:"name"`
+	Volume      float64                       `xml:"volume"`
+	IsMuted     bool                          `xml:"isMuted"`
+	PanL        float64                       `xml:"pan_L"`
+	PanR        float64                       `xml:"pan_R"`
+	Gain        float64                       `xml:"gain"`
+	MuteGroup   int                           `xml:"muteGroup"`
+	MidiOutNote int                           `xml:"midiOutNote"`
+	SampleAlgo  string                        `xml:"sampleSelectionAlgo,omitempty"`
+	Components  []hydrogenInstrumentComponent `xml:"instrumentComponent"`
+	Layers      []hydrogenLayer               `xml:"layer"` // only found in drumkits made by Hydrogen < 0.9.7
+}
+
+type hydrogenInstrumentComponent struct {
+	ComponentID int             `xml:"component_id"`
+	Gain        float64         `xml:"gain"`
+	Layers      []hydrogenLayer `xml:"layer"`
+}
+
+type hydrogenLayer struct {
+	Filename string  `xml:"filename"`
+	Min      float64 `xml:"min"`
+	Max      float64 `xml:"max"`
+	Gain     float64 `xml:"gain"`
+	Pitch    float64 `xml:"pitch"`
+}
+
+// exportHydrogenDrumkit renders the velocity layers and round-robin variants of all pads
+// and writes them to a new Hydrogen drumkit directory
+func exportHydrogenDrumkit(directory, kitName string) (string, error) {
+	if kitName == "" {
+		return "", errors.New("no drumkit name provided")
+	}
+	kitDirectory := filepath.Join(directory, kitName)
+	if err := os.MkdirAll(kitDirectory, 0o755); err != nil {
+		return "", err
+	}
+	kit := hydrogenDrumkit{
+		Namespace:  hydrogenNamespace,
+		Name:       kitName,
+		Author:     "kickpad",
+		Info:       "Generated by " + versionString,
+		License:    "undefined license",
+		Components: []hydrogenDrumkitComponent{{ID: 0, Name: "Main", Volume: 1}},
+	}
+	for i, cfg := range pads {
+		opts := padOpts[i]
+		// the files of stereo pads are already panned, see renderChannels
+		panL, panR := panGains(opts.Pan)
+		if cfg.Channels == 2 {
+			panL, panR = 1, 1
+		}
+		instrument := hydrogenInstrument{
+			ID:          i,
+			Name:        fmt.Sprintf("%s %s", padLabel(i), cfg.SoundType),
+			Volume:      math.Pow(10, opts.Gain/20),
+			IsMuted:     opts.Mute,
+			PanL:        panL,
+			PanR:        panR,
+			Gain:        1,
+			MuteGroup:   hydrogenMuteGroup(opts.ChokeGroup),
+			MidiOutNote: 36 + i,
+			SampleAlgo:  "VELOCITY",
+		}
+		if padOpts[i].RoundRobin > 1 {
+			instrument.SampleAlgo = "ROUND_ROBIN"
+		}
+		fileNames, err := exportVariations(i, kitDirectory)
+		if err != nil {
+			return "", err
+		}
+		component := hydrogenInstrumentComponent{ComponentID: 0, Gain: 1}
+		layers := padOpts[i].VelocityLayers
+		for n, fileName := range fileNames {
+			layer := n / padOpts[i].RoundRobin
+			component.Layers = append(component.Layers, hydrogenLayer{
+				Filename: filepath.Base(fileName),
+				Min:      float64(layer) / float64(layers),
+				Max:      layerVelocity(layer, layers),
+				Gain:     1,
+			})
+		}
+		instrument.Components = append(instrument.Components, component)
+		kit.Instruments = append(kit.Instruments, instrument)
+	}
+	data, err := xml.MarshalIndent(kit, "", " ")
+	if err != nil {
+		return "", err
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := os.WriteFile(filepath.Join(kitDirectory, hydrogenDrumkitFile), data, 0o644); err != nil {
+		return "", err
+	}
+	return kitDirectory, nil
+}
+
+// hydrogenMuteGroup returns the mute group of an instrument for a choke group, where Hydrogen uses -1 for no group
+func hydrogenMuteGroup(chokeGroup int) int {
+	if chokeGroup <= 0 {
+		return -1
+	}
+	return chokeGroup
+}
+
+// loadHydrogenDrumkit reads a drumkit.xml file, or the drumkit.xml file in the given directory
+func loadHydrogenDrumkit(path string) (*hydrogenDrumkit, string, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, hydrogenDrumkitFile)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var kit hydrogenDrumkit
+	if err := xml.Unmarshal(data, &kit); err != nil {
+		return nil, "", fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	return &kit, filepath.Dir(path), nil
+}
+
+// loudestLayer returns the sample filename of the layer that covers the highest velocity
+func (instrument *hydrogenInstrument) loudestLayer() (string, bool) {
+	layers := instrument.Layers
+	for _, component := range instrument.Components {
+		layers = append(layers, component.Layers...)
+	}
+	if len(layers) == 0 {
+		return "", false
+	}
+	loudest := layers[0]
+	for _, layer := range layers[1:] {
+		if layer.Max > loudest.Max {
+			loudest = layer
+		}
+	}
+	return loudest.Filename, true
+}
+
+// loadHydrogenTarget loads the sample of the drumkit instrument that has the same position as the given pad
+func loadHydrogenTarget(path string, padIndex int) error {
+	kit, kitDirectory, err := loadHydrogenDrumkit(path)
+	if err != nil {
+		return err
+	}
+	if padIndex >= len(kit.Instruments) {
+		return fmt.Errorf("drumkit %s has no instrument for %s", kit.Name, padLabel(padIndex))
+	}
+	instrument := &kit.Instruments[padIndex]
+	fileName, ok := instrument.loudestLayer()
+	if !ok {
+		return fmt.Errorf("instrument %s in drumkit %s has no samples", instrument.Name, kit.Name)
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(kitDirectory, fileName)
+	}
+	if err := loadWavPath(fileName); err != nil {
+		return err
+	}
+	setStatusMessage(fmt.Sprintf("Loaded %s from drumkit %s as the target", instrument.Name, kit.Name))
+	return nil
+}
+
+func hydrogenPopupWidget() g.Widget {
+	return g.PopupModal(hydrogenPopup).Layout(
+		g.Row(
+			g.Label("Kit name"),
+			g.InputText(&kitName).Size(200),
+		),
+		g.Row(
+			g.Label("Directory"),
+			g.InputText(&exportDirectory).Size(200),
+		),
+		g.Label("Velocity layers and round robin are set per pad, in the Variation tab."),
+		g.Row(
+			g.Button("Export").OnClick(func() {
+				kitDirectory, err := exportHydrogenDrumkit(exportDirectory, kitName)
+				if err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to export Hydrogen drumkit: %v", err))
+				} else {
+					setStatusMessage(fmt.Sprintf("Exported Hydrogen drumkit to %s", kitDirectory))
+				}
+				g.CloseCurrentPopup()
+			}),
+			g.Button(fmt.Sprintf("Load target for %s", padLabel(activePadIndex))).OnClick(func() {
+				if err := loadHydrogenTarget(filepath.Join(exportDirectory, kitName), activePadIndex); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to load Hydrogen drumkit: %v", err))
+				}
+				g.CloseCurrentPopup()
+			}),
+			g.Button("Close").OnClick(func() {
+				g.CloseCurrentPopup()
+			}),
+		),
+	)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AllenDang/cimgui-go/imgui"
+	g "github.com/AllenDang/giu"
+)
+
+// keyBindings maps actions to keys, like "Ctrl+Shift+Z". Several keys can be given, separated by commas.
+type keyBindings map[string]string
+
+var keys = defaultKeyBindings()
+
+// padKeys is a 4x4 block of keys on a QWERTY keyboard, one for each pad
+var padKeys = [numPads]string{
+	"1", "2", "3", "4",
+	"Q", "W", "E", "R",
+	"A", "S", "D", "F",
+	"Z", "X", "C", "V",
+}
+
+func defaultKeyBindings() keyBindings {
+	b := keyBindings{
+		"left":      "Left",
+		"right":     "Right",
+		"up":        "Up",
+		"down":      "Down",
+		"play":      "Space",
+		"randomize": "Ctrl+R",
+		"save":      "Ctrl+S",
+		"training":  "Ctrl+T",
+		"undo":      "Ctrl+Z",
+		"redo":      "Ctrl+Shift+Z, Ctrl+Y",
+		"copy":      "Ctrl+C",
+		"paste":     "Ctrl+V",
+		"duplicate": "Ctrl+D",
+	}
+	for i, key := range padKeys {
+		b[fmt.Sprintf("pad%d", i+1)] = key
+	}
+	return b
+}
+
+// configPath returns the path to a configuration file, typically in ~/.config/kickpad/
+func configPath(fileName string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "kickpad", fileName), nil
+}
+
+// loadKeyBindings returns the default key bindings, overridden by the bindings in the given file, if it exists
+func loadKeyBindings(filePath string) (keyBindings, error) {
+	b := defaultKeyBindings()
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	} else if err != nil {
+		return b, err
+	}
+	var custom keyBindings
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return b, fmt.Errorf("%s: %v", filePath, err)
+	}
+	for action, key := range custom {
+		if _, ok := b[action]; !ok {
+			return b, fmt.Errorf("%s: unknown action %q", filePath, action)
+		}
+		b[action] = key
+	}
+	return b, nil
+}
+
+var keyNames = map[string]g.Key{
+	"space":     g.KeySpace,
+	"enter":     g.KeyEnter,
+	"escape":    g.KeyEscape,
+	"tab":       g.KeyTab,
+	"backspace": g.KeyBackspace,
+	"insert":    g.KeyInsert,
+	"delete":    g.KeyDelete,
+	"home":      g.KeyHome,
+	"end":       g.KeyEnd,
+	"pageup":    g.KeyPageUp,
+	"pagedown":  g.KeyPageDown,
+	"left":      g.KeyLeft,
+	"right":     g.KeyRight,
+	"up":        g.KeyUp,
+	"down":      g.KeyDown,
+	"-":         g.KeyMinus,
+	"=":         g.KeyEqual,
+	",":         g.KeyComma,
+	".":         g.KeyPeriod,
+	"/":         g.KeySlash,
+	";":         g.KeySemicolon,
+	"'":         g.KeyApostrophe,
+	"[":         g.KeyLeftBracket,
+	"]":         g.KeyRightBracket,
+	"\\":        g.KeyBackslash,
+	"`":         g.KeyGraveAccent,
+}
+
+func init() {
+	for i := 0; i < 10; i++ {
+		keyNames[fmt.Sprint(i)] = g.Key0 + g.Key(i)
+	}
+	for i := 0; i < 26; i++ {
+		keyNames[strings.ToLower(string(rune('A'+i)))] = g.KeyA + g.Key(i)
+	}
+	for i := 0; i < 12; i++ {
+		keyNames[fmt.Sprintf("f%d", i+1)] = g.KeyF1 + g.Key(i)
+	}
+}
+
+// parseKey parses a key combination like "Ctrl+Shift+Z"
+func parseKey(combination string) (g.Key, g.Modifier, error) {
+	parts := strings.Split(combination, "+")
+	modifier := g.ModNone
+	for _, part := range parts[:len(parts)-1] {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "ctrl", "control":
+			modifier |= g.ModControl
+		case "shift":
+			modifier |= g.ModShift
+		case "alt":
+			modifier |= g.ModAlt
+		case "super", "cmd":
+			modifier |= g.ModSuper
+		default:
+			return 0, 0, fmt.Errorf("unknown modifier %q in %q", part, combination)
+		}
+	}
+	name := strings.ToLower(strings.TrimSpace(parts[len(parts)-1]))
+	key, ok := keyNames[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown key %q in %q", name, combination)
+	}
+	return key, modifier, nil
+}
+
+// shortcut returns the first key for an action, for showing in menus
+func shortcut(action string) string {
+	first, _, _ := strings.Cut(keys[action], ",")
+	return strings.TrimSpace(first)
+}
+
+// keyActions returns the function that is called for each action
+func keyActions() map[string]func() {
+	actions := map[string]func(){
+		"left":      func() { moveActivePad(0, -1) },
+		"right":     func() { moveActivePad(0, 1) },
+		"up":        func() { moveActivePad(-1, 0) },
+		"down":      func() { moveActivePad(1, 0) },
+		"play":      func() { triggerPad(activePadIndex) },
+		"randomize": func() { randomizePad(activePadIndex) },
+		"save":      func() { exportAndReport([]int{activePadIndex}) },
+		"training":  toggleTraining,
+		"undo":      undo,
+		"redo":      redo,
+		"copy":      func() { copyPad(activePadIndex) },
+		"paste":     func() { pastePad(activePadIndex) },
+		"duplicate": func() { duplicatePad(activePadIndex) },
+	}
+	for i := 0; i < numPads; i++ {
+		padIndex := i
+		actions[fmt.Sprintf("pad%d", i+1)] = func() {
+			activePadIndex = padIndex
+			triggerPad(padIndex)
+		}
+	}
+	return actions
+}
+
+// registerKeyBindings registers the keyboard shortcuts for all actions. Keys that can not be parsed are skipped and reported,
+// and so are keys that are bound to more than one action, which keep the action that comes first alphabetically.
+func registerKeyBindings(wnd *g.MasterWindow, b keyBindings) error {
+	actions := keyActions()
+	var (
+		shortcuts []g.WindowShortcut
+		problems  []string
+	)
+	type binding struct {
+		key      g.Key
+		modifier g.Modifier
+	}
+	boundTo := make(map[binding]string)
+	names := make([]string, 0, len(b))
+	for action := range b {
+		names = append(names, action)
+	}
+	sort.Strings(names)
+	for _, action := range names {
+		combinations := b[action]
+		callback, ok := actions[action]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown action %q", action))
+			continue
+		}
+		for _, combination := range strings.Split(combinations, ",") {
+			if strings.TrimSpace(combination) == "" {
+				continue
+			}
+			key, modifier, err := parseKey(combination)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			if other, ok := boundTo[binding{key, modifier}]; ok {
+				problems = append(problems, fmt.Sprintf("%q is bound to both %q and %q", strings.TrimSpace(combination), other, action))
+				continue
+			}
+			boundTo[binding{key, modifier}] = action
+			shortcuts = append(shortcuts, g.WindowShortcut{Key: key, Modifier: modifier, Callback: unlessTyping(callback)})
+		}
+	}
+	wnd.RegisterKeyboardShortcuts(shortcuts...)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// moveActivePad moves the active pad within the 4x4 grid
+func moveActivePad(rowDelta, colDelta int) {
+	row := int(clamp(float64(activePadIndex/4+rowDelta), 0, 3))
+	col := int(clamp(float64(activePadIndex%4+colDelta), 0, 3))
+	activePadIndex = row*4 + col
+}
+
+// unlessTyping wraps a keyboard shortcut callback, so that it is not triggered while text is being edited
+func unlessTyping(f func()) func() {
+	return func() {
+		if !imgui.CurrentIO().WantTextInput() {
+			f()
+		}
+	}
+}
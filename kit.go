@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	kitPopup       = "Kit"
+	defaultKitPath = "kit.kickpad"
+)
+
+// kitPad is one pad in a kit file
+type kitPad struct {
+	Options  padOptions
+	Settings storedSettings
+}
+
+// kitFile is all pads, stored as JSON, so that a kit can be saved and loaded again
+type kitFile struct {
+	Version string
+	Name    string
+	Pads    []kitPad
+}
+
+var kitPath = defaultKitPath
+
+func saveKit(filePath string) error {
+	kit := kitFile{Version: versionString, Name: kitName}
+	for i := 0; i < numPads; i++ {
+		kit.Pads = append(kit.Pads, kitPad{Options: padOpts[i], Settings: storeSettings(pads[i])})
+	}
+	data, err := json.MarshalIndent(kit, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+// loadKit replaces the pads with the pads in a kit file. Pads that are not in the file are left as they are.
+func loadKit(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var kit kitFile
+	if err := json.Unmarshal(data, &kit); err != nil {
+		return fmt.Errorf("%s is not a kit file: %v", filePath, err)
+	}
+	if len(kit.Pads) > numPads {
+		return fmt.Errorf("%s has %d pads, but there are only %d", filePath, len(kit.Pads), numPads)
+	}
+	for i, pad := range kit.Pads {
+		if pad.Settings.SampleRate <= 0 || pad.Settings.Channels <= 0 || pad.Settings.Duration <= 0 {
+			return fmt.Errorf("%s has invalid settings for %s", filePath, padLabel(i))
+		}
+	}
+	padIndices := make([]int, len(kit.Pads))
+	for i := range padIndices {
+		padIndices[i] = i
+	}
+	recordEdit(fmt.Sprintf("Load %s", filePath), padIndices...)
+	for i, pad := range kit.Pads {
+		restoreMetadata(i, &wavMetadata{Version: kit.Version, Options: pad.Options, Settings: pad.Settings})
+	}
+	if kit.Name != "" {
+		kitName = kit.Name
+	}
+	return nil
+}
+
+func kitPopupWidget() g.Widget {
+	return g.PopupModal(kitPopup).Layout(
+		g.Row(
+			g.Label("Kit name"),
+			g.InputText(&kitName).Size(200),
+		),
+		g.Row(
+			g.Label("Kit file"),
+			g.InputText(&kitPath).Size(200),
+		),
+		g.Row(
+			g.Button("Save").OnClick(func() {
+				if err := saveKit(kitPath); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to save the kit: %v", err))
+				} else {
+					setStatusMessage(fmt.Sprintf("Saved the kit to %s", kitPath))
+				}
+				g.CloseCurrentPopup()
+			}),
+			g.Button("Load").OnClick(func() {
+				if err := loadKit(kitPath); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to load the kit: %v", err))
+				} else {
+					setStatusMessage(fmt.Sprintf("Loaded the kit from %s", kitPath))
+				}
+				g.CloseCurrentPopup()
+			}),
+			g.Button("Close").OnClick(func() {
+				g.CloseCurrentPopup()
+			}),
+		),
+	)
+}
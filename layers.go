@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+// maxSynthLayers is the most layers a pad can have, including the pad itself
+const maxSynthLayers = 4
+
+// layerMix is how a layer is mixed into the sound of a pad. The zero value mixes the layer as it is.
+type layerMix struct {
+	Gain     float64 // dB
+	Delay    float64 // ms
+	HighPass float64 // Hz, 0 for no high-pass filter
+	LowPass  float64 // Hz, 0 for no low-pass filter
+}
+
+// synthLayer is a sound that is played together with the sound of a pad, like a click layer or a sub layer on a kick
+type synthLayer struct {
+	Mix      layerMix
+	Settings *synth.Settings
+}
+
+// storedLayer is how a synthLayer is stored in kit files and WAV metadata
+type storedLayer struct {
+	Mix      layerMix
+	Settings storedSettings
+}
+
+// padSound is everything that is rendered for a pad: how the settings of the pad are mixed,
+// the other layers, and the effects that are applied to the mix
+type padSound struct {
+	Mix     layerMix
+	Layers  []synthLayer
+	Effects padEffects
+	// Sample is played instead of the settings of the pad. It is not part of the key, see padSample.key.
+	Sample *padSample `json:"-"`
+}
+
+var activeSynthLayer int
+
+func (l synthLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(storedLayer{Mix: l.Mix, Settings: storeSettings(l.Settings)})
+}
+
+func (l *synthLayer) UnmarshalJSON(data []byte) error {
+	var stored storedLayer
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	if stored.Settings.SampleRate <= 0 || stored.Settings.Duration <= 0 {
+		return errors.New("invalid settings for a layer")
+	}
+	l.Mix, l.Settings = stored.Mix, stored.Settings.settings()
+	return nil
+}
+
+// clone returns a copy of the options that does not share any layers or sample settings with the original
+func (o padOptions) clone() padOptions {
+	o.Layers = cloneLayers(o.Layers)
+	if o.Sample != nil {
+		sample := *o.Sample
+		o.Sample = &sample
+	}
+	return o
+}
+
+func cloneLayers(layers []synthLayer) []synthLayer {
+	if layers == nil {
+		return nil
+	}
+	clones := make([]synthLayer, len(layers))
+	for i, layer := range layers {
+		clones[i] = synthLayer{Mix: layer.Mix, Settings: synth.CopySettings(layer.Settings)}
+	}
+	return clones
+}
+
+// sound returns what is rendered for the pad, besides its settings
+func (o padOptions) sound() padSound {
+	return padSound{Mix: o.LayerMix, Layers: o.Layers, Effects: o.Effects, Sample: o.Sample}
+}
+
+// layered returns true if the settings of the pad are not rendered as they are
+func (s padSound) layered() bool {
+	return len(s.Layers) > 0 || s.Mix != layerMix{}
+}
+
+// key identifies the sound in the render cache
+func (s padSound) key() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// withVelocity returns the sound with every layer rendered as if it was hit with the given velocity
+func (s padSound) withVelocity(velocity float64) padSound {
+	layers := make([]synthLayer, len(s.Layers))
+	for i, layer := range s.Layers {
+		layers[i] = synthLayer{Mix: layer.Mix, Settings: velocitySettings(layer.Settings, velocity)}
+	}
+	s.Layers = layers
+	return s
+}
+
+// withMainLayer returns the sound with the given layer in the place of the settings of the pad, and the settings
+// of the pad, main, as one of the other layers. The optimizer uses this to train a single layer of a pad.
+func (s padSound) withMainLayer(layer int, main *synth.Settings) padSound {
+	if layer <= 0 || layer > len(s.Layers) {
+		return s
+	}
+	layers := append([]synthLayer(nil), s.Layers...)
+	layers[layer-1] = synthLayer{Mix: s.Mix, Settings: main}
+	s.Mix = s.Layers[layer-1].Mix
+	s.Layers = layers
+	return s
+}
+
+// apply returns a delayed, filtered and amplified copy of the samples, which is longer than the samples by the delay
+func (m layerMix) apply(samples []float64, sampleRate int) []float64 {
+	delay := int(math.Max(m.Delay, 0) / 1000 * float64(sampleRate))
+	out := make([]float64, delay+len(samples))
+	copy(out[delay:], samples)
+	nyquist := float64(sampleRate) / 2
+	if m.HighPass > 0 {
+		passFilter(math.Min(m.HighPass, nyquist*0.9), true, sampleRate).process(out)
+	}
+	if m.LowPass > 0 {
+		passFilter(math.Min(m.LowPass, nyquist*0.9), false, sampleRate).process(out)
+	}
+	gain := math.Pow(10, m.Gain/20)
+	for i := range out {
+		out[i] *= gain
+	}
+	return out
+}
+
+// passFilter is a Butterworth high-pass or low-pass filter
+func passFilter(frequency float64, high bool, sampleRate int) *biquad {
+	w := 2 * math.Pi * frequency / float64(sampleRate)
+	cos := math.Cos(w)
+	alpha := math.Sin(w) / math.Sqrt2
+	if high {
+		return newBiquad((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+	}
+	return newBiquad((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// mixLayers mixes the samples of the settings of a pad with the samples of the other layers, which have the same sample rate
+func (s padSound) mixLayers(samples []float64, layerSamples [][]float64, sampleRate int) []float64 {
+	mixed := s.Mix.apply(samples, sampleRate)
+	for i, layer := range s.Layers {
+		for j, sample := range layer.Mix.apply(layerSamples[i], sampleRate) {
+			if j >= len(mixed) {
+				mixed = append(mixed, sample)
+			} else {
+				mixed[j] += sample
+			}
+		}
+	}
+	return mixed
+}
+
+// renderSound returns the sound of the settings, or of the sample, with the other layers and the effects, at the given sample rate.
+// Both the processed sound and the resampled sound are kept in the render cache. The returned samples must not be modified.
+func renderSound(cfg *synth.Settings, sound padSound, sampleRate int) ([]float64, error) {
+	if !sound.layered() && sound.Sample == nil {
+		return renderEffected(cfg, sound.Effects, sampleRate)
+	}
+	layerSamples := make([][]float64, len(sound.Layers))
+	for i, layer := range sound.Layers {
+		layerCfg := synth.CopySettings(layer.Settings)
+		layerCfg.SampleRate = cfg.SampleRate
+		layerCfg.BitDepth = cfg.BitDepth
+		samples, err := renders.render(layerCfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not render layer %d: %v", i+2, err)
+		}
+		layerSamples[i] = samples
+	}
+	if sound.Sample != nil {
+		// only the sample rate and bit depth of the settings are used, for the sample and the other layers
+		name := fmt.Sprintf("sample %s %s %d %d %d", sound.Sample.key(), sound.key(), cfg.SampleRate, cfg.BitDepth, sampleRate)
+		return renders.cached(name, sound.Sample.Samples, func() []float64 {
+			mixed := sound.mixLayers(sound.Sample.render(cfg.SampleRate), layerSamples, cfg.SampleRate)
+			return synth.Resample(sound.Effects.apply(mixed, cfg.SampleRate), cfg.SampleRate, sampleRate)
+		}), nil
+	}
+	name := "sound " + sound.key()
+	processed, err := renders.analyze(cfg, name, func(samples []float64) []float64 {
+		return sound.Effects.apply(sound.mixLayers(samples, layerSamples, cfg.SampleRate), cfg.SampleRate)
+	})
+	if err != nil || cfg.SampleRate == sampleRate {
+		return processed, err
+	}
+	return renders.analyze(cfg, fmt.Sprintf("%s resampled %d", name, sampleRate), func([]float64) []float64 {
+		return synth.Resample(processed, cfg.SampleRate, sampleRate)
+	})
+}
+
+// padSoundKey identifies everything that is rendered for a pad, so that measurements of it can be reused until it changes
+func padSoundKey(padIndex int) string {
+	opts := padOpts[padIndex]
+	key := fmt.Sprintf("%v %s", storeSettings(pads[padIndex]), opts.sound().key())
+	if opts.Sample != nil {
+		key += " sample " + opts.Sample.key()
+	}
+	if pads[padIndex].Channels > 1 {
+		key += fmt.Sprintf(" pan %g width %g %d variation %g seed %d", opts.Pan, opts.Width, opts.WidthMode, opts.StereoVariation, opts.Seed)
+	}
+	return key
+}
+
+// layerSettings returns the settings of a layer of a pad, where layer 0 is the pad itself
+func layerSettings(padIndex, layer int) *synth.Settings {
+	if layer <= 0 || layer > len(padOpts[padIndex].Layers) {
+		return pads[padIndex]
+	}
+	return padOpts[padIndex].Layers[layer-1].Settings
+}
+
+// setLayerSettings replaces the settings of a layer of a pad, where layer 0 is the pad itself.
+// The pad keeps its format.
+func setLayerSettings(padIndex, layer int, cfg *synth.Settings) {
+	if layer <= 0 {
+		formatOf(pads[padIndex]).applyTo(cfg)
+		pads[padIndex] = cfg
+	} else if layer <= len(padOpts[padIndex].Layers) {
+		padOpts[padIndex].Layers[layer-1].Settings = cfg
+	}
+}
+
+// layerMixOf returns how a layer of a pad is mixed, where layer 0 is the pad itself
+func layerMixOf(padIndex, layer int) *layerMix {
+	if layer <= 0 || layer > len(padOpts[padIndex].Layers) {
+		return &padOpts[padIndex].LayerMix
+	}
+	return &padOpts[padIndex].Layers[layer-1].Mix
+}
+
+// createLayersWidget selects the layer of the active pad that the sliders change, and shows how that layer is mixed
+func createLayersWidget() g.Widget {
+	opts := &padOpts[activePadIndex]
+	layerCount := 1 + len(opts.Layers)
+	if activeSynthLayer >= layerCount {
+		activeSynthLayer = layerCount - 1
+	}
+	var layerNames []string
+	for i := 0; i < layerCount; i++ {
+		layerNames = append(layerNames, fmt.Sprintf("Layer %d (%s)", i+1, layerSettings(activePadIndex, i).SoundType))
+	}
+	if opts.Sample != nil {
+		layerNames[0] = "Layer 1 (sample)"
+	}
+	selected := int32(activeSynthLayer)
+	mix := layerMixOf(activePadIndex, activeSynthLayer)
+	return g.Column(
+		g.Row(
+			g.Combo("##synthLayer", layerNames[selected], layerNames, &selected).Size(150).OnChange(func() {
+				activeSynthLayer = int(selected)
+			}),
+			g.Button("Add layer").Disabled(layerCount >= maxSynthLayers).OnClick(func() {
+				recordEdit(fmt.Sprintf("%s: Add layer", padLabel(activePadIndex)), activePadIndex)
+				layer := formatOf(pads[activePadIndex]).newRandom(synth.Kick)
+				padOpts[activePadIndex].Layers = append(padOpts[activePadIndex].Layers, synthLayer{Settings: layer})
+				activeSynthLayer = len(padOpts[activePadIndex].Layers)
+			}),
+			g.Button("Remove layer").Disabled(activeSynthLayer == 0).OnClick(func() {
+				recordEdit(fmt.Sprintf("%s: Remove layer", padLabel(activePadIndex)), activePadIndex)
+				layers := padOpts[activePadIndex].Layers
+				padOpts[activePadIndex].Layers = append(layers[:activeSynthLayer-1:activeSynthLayer-1], layers[activeSynthLayer:]...)
+				activeSynthLayer--
+			}),
+		),
+		g.TreeNode(fmt.Sprintf("Layer %d mix", activeSynthLayer+1)).Layout(
+			effectSlider("Layer gain (dB)", &mix.Gain, minPadGain, maxPadGain),
+			effectSlider("Layer delay (ms)", &mix.Delay, 0, 50),
+			effectSlider("High-pass (Hz, 0 is off)", &mix.HighPass, 0, 2000),
+			effectSlider("Low-pass (Hz, 0 is off)", &mix.LowPass, 0, 20000),
+		),
+	)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	// silence is the level that is shown for sounds without any signal, in dB
+	silence = -120.0
+	// shortTermWindow is the window of the short-term loudness, in seconds, see ITU-R BS.1770 and EBU R 128
+	shortTermWindow = 3.0
+	shortTermHop    = 0.1
+	// truePeakOversampling is how many times the sound is oversampled to find peaks between the samples
+	truePeakOversampling = 4
+	truePeakTaps         = 12
+	// meterRange is the lowest level that is shown by the output meter, in dB
+	meterRange = -60.0
+
+	normalizeNone = 0
+	normalizePeak = 1
+	normalizeLUFS = 2
+)
+
+// loudness is how loud a sound is, in dBFS, dBTP and LUFS
+type loudness struct {
+	Peak     float64
+	TruePeak float64
+	RMS      float64
+	LUFS     float64
+}
+
+var (
+	normalizeModes         = []string{"None", "Peak", "LUFS"}
+	exportNormalize  int32 = normalizeNone
+	exportPeakTarget       = float32(-1)
+	exportLUFSTarget       = float32(-14)
+
+	// trainLoudnessInvariant makes the training only match the shape of the WAV, and not how loud it is
+	trainLoudnessInvariant bool
+
+	// the loudness of the active pad is only measured again when its sound changes
+	lastLoudnessKey string
+	lastLoudness    loudness
+)
+
+func decibels(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return silence
+	}
+	return math.Max(20*math.Log10(amplitude), silence)
+}
+
+// measureLoudness returns the peak, true peak, RMS level and the highest short-term loudness of a sound,
+// with the given number of interleaved channels. Sounds that are shorter than the short-term window are measured as a whole.
+func measureLoudness(samples []float64, sampleRate, channels int) loudness {
+	peak, sum := 0.0, 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, math.Abs(sample))
+		sum += sample * sample
+	}
+	rms := 0.0
+	if len(samples) > 0 {
+		rms = math.Sqrt(sum / float64(len(samples)))
+	}
+	split := deinterleave(samples, channels)
+	highest := peak
+	for _, channel := range split {
+		highest = math.Max(highest, truePeak(channel))
+	}
+	return loudness{
+		Peak:     decibels(peak),
+		TruePeak: decibels(highest),
+		RMS:      decibels(rms),
+		LUFS:     shortTermLoudness(split, sampleRate),
+	}
+}
+
+// truePeak returns the highest level between the samples, with windowed sinc interpolation
+func truePeak(samples []float64) float64 {
+	peak := 0.0
+	for phase := 1; phase < truePeakOversampling; phase++ {
+		offset := float64(phase) / truePeakOversampling
+		kernel := make([]float64, 2*truePeakTaps)
+		for k := range kernel {
+			x := float64(k-truePeakTaps+1) - offset
+			window := 0.5 + 0.5*math.Cos(math.Pi*x/truePeakTaps)
+			kernel[k] = math.Sin(math.Pi*x) / (math.Pi * x) * window
+		}
+		for i := range samples {
+			value := 0.0
+			for k, weight := range kernel {
+				if j := i + k - truePeakTaps + 1; j >= 0 && j < len(samples) {
+					value += samples[j] * weight
+				}
+			}
+			peak = math.Max(peak, math.Abs(value))
+		}
+	}
+	return peak
+}
+
+// kWeighting returns the filters of the K-weighting of ITU-R BS.1770, a high shelf followed by a high-pass filter.
+// The coefficients are calculated for the sample rate, in a way that gives the coefficients of the standard at 48 kHz.
+func kWeighting(sampleRate int) []*biquad {
+	const (
+		shelfFrequency = 1681.974450955533
+		shelfGain      = 3.999843853973347 // dB
+		shelfQ         = 0.7071752369554196
+		passFrequency  = 38.13547087602444
+		passQ          = 0.5003270373238773
+	)
+	k := math.Tan(math.Pi * shelfFrequency / float64(sampleRate))
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	shelf := newBiquad(vh+vb*k/shelfQ+k*k, 2*(k*k-vh), vh-vb*k/shelfQ+k*k, 1+k/shelfQ+k*k, 2*(k*k-1), 1-k/shelfQ+k*k)
+	k = math.Tan(math.Pi * passFrequency / float64(sampleRate))
+	// the numerator of the high-pass filter of the standard is 1, -2, 1, it is not divided by a0
+	a0 := 1 + k/passQ + k*k
+	highPass := newBiquad(a0, -2*a0, a0, a0, 2*(k*k-1), 1-k/passQ+k*k)
+	return []*biquad{shelf, highPass}
+}
+
+// shortTermLoudness returns the highest loudness of the K-weighted channels in a sliding window, in LUFS.
+// The mean squares of the channels are added up, as for the left and right channel in ITU-R BS.1770.
+func shortTermLoudness(channels [][]float64, sampleRate int) float64 {
+	weighted := make([][]float64, len(channels))
+	for c, samples := range channels {
+		weighted[c] = append([]float64(nil), samples...)
+		for _, filter := range kWeighting(sampleRate) {
+			filter.process(weighted[c])
+		}
+	}
+	window := min(int(shortTermWindow*float64(sampleRate)), len(weighted[0]))
+	if window == 0 {
+		return silence
+	}
+	hop := int(shortTermHop * float64(sampleRate))
+	highest := 0.0
+	for start := 0; ; start += hop {
+		sum := 0.0
+		for _, channel := range weighted {
+			for _, sample := range channel[start : start+window] {
+				sum += sample * sample
+			}
+		}
+		highest = math.Max(highest, sum/float64(window))
+		if start+hop+window > len(weighted[0]) {
+			break
+		}
+	}
+	if highest == 0 {
+		return silence
+	}
+	return math.Max(-0.691+10*math.Log10(highest), silence)
+}
+
+// normalizationGain returns the gain that makes the peak or the loudness of the interleaved samples the target, in dBFS or LUFS.
+// The gain is limited so that the normalized sound does not clip.
+func normalizationGain(samples []float64, sampleRate, channels, mode int, target float64) float64 {
+	if mode == normalizeNone || len(samples) == 0 {
+		return 1
+	}
+	measured := measureLoudness(samples, sampleRate, channels)
+	level := measured.Peak
+	if mode == normalizeLUFS {
+		level = measured.LUFS
+	}
+	if level <= silence {
+		return 1
+	}
+	return math.Min(math.Pow(10, (target-level)/20), math.Pow(10, -measured.Peak/20))
+}
+
+// normalizationTarget returns the target level of the selected normalization of exports
+func normalizationTarget() float64 {
+	if exportNormalize == normalizeLUFS {
+		return float64(exportLUFSTarget)
+	}
+	return float64(exportPeakTarget)
+}
+
+// activePadLoudness returns the loudness of the active pad as it is exported, and only measures it again if the sound of the pad has changed
+func activePadLoudness() loudness {
+	if key := padSoundKey(activePadIndex); key != lastLoudnessKey {
+		lastLoudnessKey = key
+		lastLoudness = loudness{Peak: silence, TruePeak: silence, RMS: silence, LUFS: silence}
+		cfg := pads[activePadIndex]
+		if samples, err := padOpts[activePadIndex].renderChannels(cfg, padOpts[activePadIndex].sound(), cfg.SampleRate); err == nil {
+			lastLoudness = measureLoudness(samples, cfg.SampleRate, cfg.Channels)
+		}
+	}
+	return lastLoudness
+}
+
+// meterBar shows a level in dB as a bar from meterRange to 0 dB
+func meterBar(name string, level float64) g.Widget {
+	fraction := float32(clamp((level-meterRange)/-meterRange, 0, 1))
+	return g.Row(
+		g.Label(name),
+		g.ProgressBar(fraction).Size(150, 0).Overlay(fmt.Sprintf("%.1f dB", level)),
+	)
+}
+
+// createLoudnessWidget shows how loud the active pad is when it is rendered, and the level of the output while playing
+func createLoudnessWidget() g.Widget {
+	l := activePadLoudness()
+	peak, rms := audioMixer.levels()
+	return g.Column(
+		g.Label(fmt.Sprintf("Peak %.1f dBFS, true peak %.1f dBTP", l.Peak, l.TruePeak)),
+		g.Label(fmt.Sprintf("RMS %.1f dBFS, short-term %.1f LUFS", l.RMS, l.LUFS)),
+		meterBar("Output peak", peak),
+		meterBar("Output RMS ", rms),
+	)
+}
+
+func createNormalizeWidget() g.Widget {
+	var targetSlider g.Widget = g.Dummy(0, 0)
+	switch exportNormalize {
+	case normalizePeak:
+		targetSlider = g.SliderFloat(&exportPeakTarget, -24, 0).Size(150).Format("%.1f dBFS")
+	case normalizeLUFS:
+		targetSlider = g.SliderFloat(&exportLUFSTarget, -40, 0).Size(150).Format("%.1f LUFS")
+	}
+	return g.Row(
+		g.Label("Normalize"),
+		g.Combo("##exportNormalize", normalizeModes[exportNormalize], normalizeModes, &exportNormalize).Size(100),
+		targetSlider,
+	)
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	_ "embed"
 	"errors"
+	"flag"
 	"fmt"
 	"image/color"
 	"log"
@@ -11,11 +11,11 @@ import (
 	"math/cmplx"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 
 	g "github.com/AllenDang/giu"
-	"github.com/go-audio/wav"
 	"github.com/mjibson/go-dsp/fft"
 	"github.com/xyproto/playsample"
 	"github.com/xyproto/synth"
@@ -23,7 +23,6 @@ import (
 
 const (
 	versionString     = "Kickpad 1.5.5"
-	channels          = 1
 	buttonSize        = 100
 	numPads           = 16
 	maxGenerations    = 1000
@@ -68,84 +67,127 @@ var (
 	wavFilePath           string
 	statusMessage         string
 	cancelTraining        chan struct{}
-	sampleRates               = []int{44100, 48000, 96000, 192000}
-	bitDepth              int = defaultBitDepth
-	sampleRate            int = sampleRates[0]
+	sampleRates           = []int{44100, 48000, 96000, 192000}
 	mu                    sync.Mutex
 	waveformSelectedIndex int32
-	sampleRateIndex       int32
-	bitDepthSelected      bool
-	player                *playsample.Player
-	muPlayer              sync.Mutex
+	pendingPopup          string
+	trainingPadIndex      int
+	trainingLayer         int
+	uiQueue               = make(chan func(), 256)
 )
 
 func loadWavData(data []byte) error {
-	reader := bytes.NewReader(data)
-	decoder := wav.NewDecoder(reader)
-	buffer, err := decoder.FullPCMBuffer()
+	audio, err := decodeAudio(data)
 	if err != nil {
 		setStatusMessage("Error: Failed to decode embedded .wav data")
 		return err
 	}
-	loadedWaveform = make([]float64, len(buffer.Data))
-	for i, sample := range buffer.Data {
-		loadedWaveform[i] = float64(sample) / math.MaxInt16
-	}
+	loadedWaveform = targetWaveform(audio)
+	loadedWaveformName = "kick909.wav"
 	setStatusMessage("Loaded embedded .wav data")
 	return nil
 }
 
 func loadWavFile() error {
-	filePath := wavFilePath
+	if err := loadWavPath(wavFilePath); err != nil {
+		return err
+	}
+	if meta, err := readWavMetadata(wavFilePath); err == nil && meta != nil {
+		pendingMetadata = meta
+		pendingPopup = restorePopup
+	}
+	return nil
+}
+
+func loadWavPath(filePath string) error {
 	if filePath == "" {
 		setStatusMessage("No .wav file path provided")
 		return errors.New("no .wav file path provided")
 	}
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		setStatusMessage(fmt.Sprintf("Error: Failed to open .wav file %s", filePath))
 		return err
 	}
-	defer file.Close()
-	decoder := wav.NewDecoder(file)
-	buffer, err := decoder.FullPCMBuffer()
+	audio, err := decodeAudio(data)
 	if err != nil {
-		setStatusMessage(fmt.Sprintf("Error: Failed to decode .wav file %s", filePath))
+		setStatusMessage(fmt.Sprintf("Error: Failed to decode %s: %v", filePath, err))
 		return err
 	}
-	loadedWaveform = make([]float64, len(buffer.Data))
-	for i, sample := range buffer.Data {
-		loadedWaveform[i] = float64(sample) / math.MaxInt16
-	}
-	setStatusMessage(fmt.Sprintf("Loaded .wav file: %s", filePath))
+	loadedWaveform = targetWaveform(audio)
+	loadedWaveformName = filepath.Base(filePath)
+	setStatusMessage(fmt.Sprintf("Loaded %s (%d Hz, %d-bit, %d channel(s))", filePath, audio.SampleRate, audio.BitDepth, audio.Channels))
 	return nil
 }
 
-func playLoadedWaveform() error {
-	muPlayer.Lock()
-	defer muPlayer.Unlock()
-	if player == nil || !player.Initialized {
-		return errors.New("audio player is not initialized")
+func writeWav(filePath string, samples []float64, sampleRate, bitDepth, channels int) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := playsample.SaveToWav(file, samples, sampleRate, bitDepth, channels); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write %s: %v", filePath, err)
 	}
+	return file.Close()
+}
+
+func playLoadedWaveform() error {
 	if loadedWaveform == nil || len(loadedWaveform) == 0 {
 		return errors.New("no waveform loaded")
 	}
-	return player.PlayWaveform(loadedWaveform, 44100, 16, 1)
+	playSamples(loadedWaveform, targetSampleRate)
+	return nil
+}
+
+// optimizerTarget is the waveform that the optimizer compares sounds with, and its spectra, which are only computed once per FFT size
+type optimizerTarget struct {
+	samples    []float64
+	sampleRate int
+	// sound is the other layers and the effects, which every sound is mixed with before it is compared with the target
+	sound padSound
+	// loudnessInvariant scales every sound to the level of the target before comparing, so that only the shape counts
+	loudnessInvariant bool
+	mu                sync.Mutex
+	spectra           map[int][]float64
+}
+
+func newOptimizerTarget(samples []float64, sampleRate int, sound padSound, loudnessInvariant bool) *optimizerTarget {
+	return &optimizerTarget{samples: samples, sampleRate: sampleRate, sound: sound, loudnessInvariant: loudnessInvariant, spectra: make(map[int][]float64)}
+}
+
+func (t *optimizerTarget) spectrum(n int) []float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if spectrum, ok := t.spectra[n]; ok {
+		return spectrum
+	}
+	spectrum := magnitudeSpectrum(t.samples, n)
+	t.spectra[n] = spectrum
+	return spectrum
 }
 
-func compareWaveformsSafe(individual *synth.Settings) float64 {
-	generatedWaveform, err := individual.Generate()
+// compareWaveformsSafe returns how different the sound of the given settings is from the target waveform.
+// Lower is better. The sound and its spectrum are taken from the render cache, if they are there.
+func compareWaveformsSafe(individual *synth.Settings, target *optimizerTarget) float64 {
+	generatedWaveform, err := renderSound(individual, target.sound, target.sampleRate)
 	if err != nil {
 		return math.Inf(1)
 	}
-	if individual.SampleRate != sampleRate {
-		generatedWaveform = synth.Resample(generatedWaveform, individual.SampleRate, sampleRate)
+	// the spectrum scales with the waveform, so the same scale is used for both
+	scale := 1.0
+	if target.loudnessInvariant {
+		scale = levelRatio(generatedWaveform, target.samples)
 	}
-	if originalSampleRate := 44100; sampleRate != originalSampleRate {
-		loadedWaveform = synth.Resample(loadedWaveform, originalSampleRate, sampleRate)
+	timeMSE := compareWaveformsScaled(generatedWaveform, target.samples, scale)
+	n := nextPowerOfTwo(min(len(generatedWaveform), len(target.samples)))
+	generatedSpectrum, err := renders.analyze(individual, fmt.Sprintf("spectrum %d %d %s", target.sampleRate, n, target.sound.key()), func([]float64) []float64 {
+		return magnitudeSpectrum(generatedWaveform, n)
+	})
+	if err != nil {
+		return math.Inf(1)
 	}
-	timeMSE := compareWaveforms(generatedWaveform, loadedWaveform)
-	freqMSE := compareWaveformsFFT(generatedWaveform, loadedWaveform, sampleRate)
+	freqMSE := compareWaveformsScaled(generatedSpectrum, target.spectrum(n), scale)
 	combinedMSE := 0.5*timeMSE + 0.5*freqMSE
 	expectedDuration := individual.Attack + individual.Decay + individual.Release
 	if expectedDuration < minSampleDuration {
@@ -159,30 +201,16 @@ func compareWaveformsSafe(individual *synth.Settings) float64 {
 	return combinedMSE
 }
 
-func compareWaveformsFFT(waveform1, waveform2 []float64, sampleRate int) float64 {
-	if waveform1 == nil || waveform2 == nil {
-		return math.Inf(1)
-	}
-	n := nextPowerOfTwo(min(len(waveform1), len(waveform2)))
-	padded1 := make([]float64, n)
-	padded2 := make([]float64, n)
-	copy(padded1, waveform1)
-	copy(padded2, waveform2)
-	complex1 := fft.FFTReal(padded1)
-	complex2 := fft.FFTReal(padded2)
-	mag1 := make([]float64, n)
-	mag2 := make([]float64, n)
-	for i := 0; i < n; i++ {
-		mag1[i] = cmplx.Abs(complex1[i])
-		mag2[i] = cmplx.Abs(complex2[i])
-	}
-	mse := 0.0
-	for i := 0; i < n; i++ {
-		diff := mag1[i] - mag2[i]
-		mse += diff * diff
+// magnitudeSpectrum returns the magnitudes of the FFT of the waveform, which is padded or cut to n samples
+func magnitudeSpectrum(waveform []float64, n int) []float64 {
+	padded := make([]float64, n)
+	copy(padded, waveform)
+	spectrum := fft.FFTReal(padded)
+	magnitudes := make([]float64, n)
+	for i := range spectrum {
+		magnitudes[i] = cmplx.Abs(spectrum[i])
 	}
-	mse /= float64(n)
-	return mse
+	return magnitudes
 }
 
 func nextPowerOfTwo(n int) int {
@@ -204,6 +232,26 @@ func min(a, b int) int {
 }
 
 func compareWaveforms(waveform1, waveform2 []float64) float64 {
+	return compareWaveformsScaled(waveform1, waveform2, 1)
+}
+
+// levelRatio returns how much the first waveform has to be scaled to have the same RMS level as the second,
+// over the length that they have in common
+func levelRatio(waveform1, waveform2 []float64) float64 {
+	n := min(len(waveform1), len(waveform2))
+	sum1, sum2 := 0.0, 0.0
+	for i := 0; i < n; i++ {
+		sum1 += waveform1[i] * waveform1[i]
+		sum2 += waveform2[i] * waveform2[i]
+	}
+	if sum1 == 0 {
+		return 1
+	}
+	return math.Sqrt(sum2 / sum1)
+}
+
+// compareWaveformsScaled is like compareWaveforms, but the first waveform is scaled first
+func compareWaveformsScaled(waveform1, waveform2 []float64, scale float64) float64 {
 	if waveform1 == nil || waveform2 == nil {
 		return math.Inf(1)
 	}
@@ -213,7 +261,7 @@ func compareWaveforms(waveform1, waveform2 []float64) float64 {
 	}
 	mse := 0.0
 	for i := 0; i < minLength; i++ {
-		diff := waveform1[i] - waveform2[i]
+		diff := waveform1[i]*scale - waveform2[i]
 		mse += diff * diff
 	}
 	return mse / float64(minLength)
@@ -225,7 +273,8 @@ func randomizeAllPads() {
 		if rand.Float64() < 0.5 {
 			randomSoundType = synth.Snare
 		}
-		pads[i] = synth.NewRandom(randomSoundType, nil, sampleRate, bitDepth, channels)
+		pads[i] = formatOf(pads[i]).newRandom(randomSoundType)
+		padInUse[i] = false
 	}
 }
 
@@ -297,16 +346,78 @@ func setStatusMessage(msg string) {
 	statusMessage = msg
 }
 
-func optimizeSettings(allWaveforms bool) {
+// runOnUI queues a change to the pads, that is then applied by the GUI loop between two frames.
+// This is how the training, MIDI and OSC goroutines change the pads safely.
+func runOnUI(f func()) {
+	uiQueue <- f
+	refreshWindow()
+}
+
+// callOnUI is like runOnUI, but waits until f has been called
+func callOnUI(f func()) {
+	done := make(chan struct{})
+	runOnUI(func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+// applyQueued applies the changes that have been queued with runOnUI
+func applyQueued() {
+	for {
+		select {
+		case f := <-uiQueue:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// refreshWindow redraws the window after a change from a background goroutine, if there is a window
+func refreshWindow() {
+	if g.Context != nil {
+		g.Update()
+	}
+}
+
+// optimizeSettings trains the layer of the pad that is being trained on the loaded WAV, and updates the layer as the
+// training improves it. The sounds are mixed with the given sound, the other layers and effects, before they are compared with the WAV,
+// at the sample rate and bit depth of the given format.
+func optimizeSettings(allWaveforms, loudnessInvariant bool, sound padSound, format padFormat) {
 	if len(loadedWaveform) == 0 {
 		setStatusMessage("Error: No .wav file loaded. Please load a .wav file first.")
 		return
 	}
 	setStatusMessage("Training started...")
+	target := loadedWaveform
+	if format.SampleRate != targetSampleRate {
+		target = synth.Resample(loadedWaveform, targetSampleRate, format.SampleRate)
+	}
+	_, bestFitness, result := runOptimizer(target, format.SampleRate, format.BitDepth, allWaveforms, loudnessInvariant, sound, cancelTraining, func(generation int, best *synth.Settings, fitness float64, improved bool) {
+		if improved {
+			runOnUI(func() { setLayerSettings(trainingPadIndex, trainingLayer, best) })
+		}
+		setStatusMessage(fmt.Sprintf("Generation %d: Best fitness = %f", generation, fitness))
+		oscBroadcast("/train/progress", int32(generation), float32(fitness))
+	})
+	atomic.StoreInt32(&trainingOngoing, 0)
+	setStatusMessage(result)
+	oscBroadcast("/train/done", float32(bestFitness))
+}
+
+// runOptimizer evolves settings towards the target waveform with a genetic algorithm, until it is canceled,
+// a near perfect match is found, or there is no more improvement. The target and the output format are
+// given, instead of being read from the globals, so that several optimizers can run at the same time.
+// Every sound is mixed with the other layers and the effects of the given sound before it is compared with the target,
+// and with loudnessInvariant, it is also scaled to the level of the target.
+// progress is called after every generation, with a copy of the best settings so far.
+func runOptimizer(target []float64, sampleRate, bitDepth int, allWaveforms, loudnessInvariant bool, sound padSound, cancel <-chan struct{}, progress func(generation int, best *synth.Settings, fitness float64, improved bool)) (*synth.Settings, float64, string) {
 	// Initialize population
 	population := make([]*synth.Settings, populationSize)
 	for i := 0; i < populationSize; i++ {
-		population[i] = synth.NewRandom(synth.Kick, nil, sampleRate, bitDepth, channels)
+		population[i] = synth.NewRandom(synth.Kick, nil, sampleRate, bitDepth, defaultFormat.Channels)
 		if !allWaveforms {
 			population[i].WaveformType = rand.Intn(2)
 		} else {
@@ -322,21 +433,27 @@ func optimizeSettings(allWaveforms bool) {
 		population[i].PitchDecay = clamp(population[i].PitchDecay, minPitchDecay, maxPitchDecay)
 		population[i].NoiseAmount = clamp(population[i].NoiseAmount, minNoiseAmount, maxNoiseAmount)
 	}
+	t := newOptimizerTarget(target, sampleRate, sound, loudnessInvariant)
 	bestSettings := synth.CopySettings(population[0])
-	bestFitness := compareWaveformsSafe(bestSettings)
+	bestFitness := compareWaveformsSafe(bestSettings, t)
 	stagnationCount := 0
-	for generation := 0; generation < maxGenerations && atomic.LoadInt32(&trainingOngoing) == 1; generation++ {
+	// the first individuals are copies of the best settings, which already have a known fitness
+	elites := 0
+	for generation := 0; generation < maxGenerations; generation++ {
 		select {
-		case <-cancelTraining:
-			setStatusMessage("Training canceled.")
-			atomic.StoreInt32(&trainingOngoing, 0)
-			return
+		case <-cancel:
+			return bestSettings, bestFitness, "Training canceled."
 		default:
 		}
 		fitnesses := make([]float64, populationSize)
 		for i, individual := range population {
-			fitnesses[i] = compareWaveformsSafe(individual)
+			if i < elites {
+				fitnesses[i] = bestFitness
+				continue
+			}
+			fitnesses[i] = compareWaveformsSafe(individual, t)
 		}
+		improved := false
 		currentBestFitness := math.Inf(1)
 		currentBestIndex := -1
 		for i, fitness := range fitnesses {
@@ -348,27 +465,25 @@ func optimizeSettings(allWaveforms bool) {
 		if currentBestIndex != -1 && fitnesses[currentBestIndex] < bestFitness {
 			bestFitness = fitnesses[currentBestIndex]
 			bestSettings = synth.CopySettings(population[currentBestIndex])
-			pads[activePadIndex] = bestSettings
-			pads[activePadIndex].SampleRate = sampleRate
-			pads[activePadIndex].BitDepth = bitDepth
+			bestSettings.SampleRate = sampleRate
+			bestSettings.BitDepth = bitDepth
+			improved = true
 			stagnationCount = 0
 			if bestFitness < 1e-3 {
-				setStatusMessage(fmt.Sprintf("Global optimum found at generation %d!", generation))
-				atomic.StoreInt32(&trainingOngoing, 0)
-				return
+				progress(generation, synth.CopySettings(bestSettings), bestFitness, improved)
+				return bestSettings, bestFitness, fmt.Sprintf("Global optimum found at generation %d!", generation)
 			}
 		} else {
 			stagnationCount++
 			if stagnationCount >= maxStagnation {
-				setStatusMessage(fmt.Sprintf("Training stopped due to no improvement in %d generations.", maxStagnation))
-				atomic.StoreInt32(&trainingOngoing, 0)
-				return
+				return bestSettings, bestFitness, fmt.Sprintf("Training stopped due to no improvement in %d generations.", maxStagnation)
 			}
 		}
 		newPopulation := make([]*synth.Settings, 0, populationSize)
 		for i := 0; i < eliteCount && i < populationSize; i++ {
 			newPopulation = append(newPopulation, synth.CopySettings(bestSettings))
 		}
+		elites = len(newPopulation)
 		for len(newPopulation) < populationSize {
 			parent1 := tournamentSelection(population, fitnesses, tournamentSize)
 			parent2 := tournamentSelection(population, fitnesses, tournamentSize)
@@ -399,45 +514,54 @@ func optimizeSettings(allWaveforms bool) {
 			newPopulation = newPopulation[:populationSize]
 		}
 		population = newPopulation
-		setStatusMessage(fmt.Sprintf("Generation %d: Best fitness = %f", generation, bestFitness))
+		progress(generation, synth.CopySettings(bestSettings), bestFitness, improved)
 	}
-	pads[activePadIndex] = bestSettings
+	return bestSettings, bestFitness, fmt.Sprintf("Training finished after %d generations.", maxGenerations)
 }
 
 func mutateSettings(cfg *synth.Settings, allWaveforms bool) {
-	if rand.Float64() < mutationRate {
-		cfg.Attack = clamp(cfg.Attack*(0.8+rand.Float64()*0.4), minAttack, maxAttack)
+	mutateSettingsWith(globalRandom{}, cfg, allWaveforms, mutationRate, 0.2)
+}
+
+// mutateSettingsWith changes each parameter with the given probability, by up to ± amount.
+// Values that are already outside of the GA ranges are not pushed further out.
+func mutateSettingsWith(r randomSource, cfg *synth.Settings, allWaveforms bool, rate, amount float64) {
+	vary := func(value, low, high float64) float64 {
+		return clamp(value*(1-amount+r.Float64()*2*amount), math.Min(low, value), math.Max(high, value))
 	}
-	if rand.Float64() < mutationRate {
-		cfg.Decay = clamp(cfg.Decay*(0.8+rand.Float64()*0.4), minDecay, maxDecay)
+	if r.Float64() < rate {
+		cfg.Attack = vary(cfg.Attack, minAttack, maxAttack)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.Sustain = clamp(cfg.Sustain*(0.8+rand.Float64()*0.4), minSustain, maxSustain)
+	if r.Float64() < rate {
+		cfg.Decay = vary(cfg.Decay, minDecay, maxDecay)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.Release = clamp(cfg.Release*(0.8+rand.Float64()*0.4), minRelease, maxRelease)
+	if r.Float64() < rate {
+		cfg.Sustain = vary(cfg.Sustain, minSustain, maxSustain)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.Drive = clamp(cfg.Drive*(0.8+rand.Float64()*0.4), minDrive, maxDrive)
+	if r.Float64() < rate {
+		cfg.Release = vary(cfg.Release, minRelease, maxRelease)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.FilterCutoff = clamp(cfg.FilterCutoff*(0.8+rand.Float64()*0.4), minFilterCutoff, maxFilterCutoff)
+	if r.Float64() < rate {
+		cfg.Drive = vary(cfg.Drive, minDrive, maxDrive)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.Sweep = clamp(cfg.Sweep*(0.8+rand.Float64()*0.4), minSweep, maxSweep)
+	if r.Float64() < rate {
+		cfg.FilterCutoff = vary(cfg.FilterCutoff, minFilterCutoff, maxFilterCutoff)
 	}
-	if rand.Float64() < mutationRate {
-		cfg.PitchDecay = clamp(cfg.PitchDecay*(0.8+rand.Float64()*0.4), minPitchDecay, maxPitchDecay)
+	if r.Float64() < rate {
+		cfg.Sweep = vary(cfg.Sweep, minSweep, maxSweep)
 	}
-	if rand.Float64() < mutationRate {
+	if r.Float64() < rate {
+		cfg.PitchDecay = vary(cfg.PitchDecay, minPitchDecay, maxPitchDecay)
+	}
+	if r.Float64() < rate {
 		if !allWaveforms {
-			cfg.WaveformType = rand.Intn(2)
+			cfg.WaveformType = r.Intn(2)
 		} else {
-			cfg.WaveformType = rand.Intn(7)
+			cfg.WaveformType = r.Intn(7)
 		}
 	}
-	if rand.Float64() < mutationRate {
-		cfg.NoiseAmount = clamp(cfg.NoiseAmount*(0.8+rand.Float64()*0.4), minNoiseAmount, maxNoiseAmount)
+	if r.Float64() < rate {
+		cfg.NoiseAmount = vary(cfg.NoiseAmount, minNoiseAmount, maxNoiseAmount)
 	}
 }
 
@@ -451,6 +575,10 @@ func clamp(value, min, max float64) float64 {
 	return value
 }
 
+func padLabel(padIndex int) string {
+	return fmt.Sprintf("Pad %d", padIndex+1)
+}
+
 func createPadWidget(cfg *synth.Settings, padLabel string, padIndex int) g.Widget {
 	buttonColor := cfg.Color()
 	padBorderColor := color.RGBA{0x0, 0x0, 0x0, 0xff}
@@ -467,22 +595,50 @@ func createPadWidget(cfg *synth.Settings, padLabel string, padIndex int) g.Widge
 			g.Style().SetColor(g.StyleColorText, padTextColor).SetColor(g.StyleColorBorder, padBorderColor).To(
 				g.Button(padLabel).Size(buttonSize, buttonSize).OnClick(func() {
 					activePadIndex = padIndex
-					setStatusMessage("")
-					go func() {
-						if err := GeneratePlay(pads[activePadIndex]); err != nil {
-							setStatusMessage(fmt.Sprintf("Error: Failed to play sound: %v", err))
-						} else {
-							setStatusMessage(fmt.Sprintf("Playing sound from %s", padLabel))
-						}
-					}()
+					triggerPad(padIndex)
 				}),
 			),
+			padDragDrop(padIndex),
 		),
 	)
 }
 
+// triggerPad plays a pad in the background, at the current play velocity
+func triggerPad(padIndex int) {
+	setStatusMessage("")
+	play := playPad(padIndex, float64(playVelocity))
+	go func() {
+		if err := play(); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Failed to play sound: %v", err))
+		} else {
+			setStatusMessage(fmt.Sprintf("Playing sound from %s", padLabel(padIndex)))
+		}
+	}()
+}
+
+func randomizePad(padIndex int) {
+	var randomSoundType synth.SoundType = synth.Kick
+	if rand.Float64() < 0.5 {
+		randomSoundType = synth.Snare
+	}
+	recordEdit(fmt.Sprintf("%s: Randomize", padLabel(padIndex)), padIndex)
+	pads[padIndex] = formatOf(pads[padIndex]).newRandom(randomSoundType)
+}
+
 func createSlidersForSelectedPad() g.Widget {
-	cfg := pads[activePadIndex]
+	layersWidget := createLayersWidget()
+	if padOpts[activePadIndex].Sample != nil && activeSynthLayer == 0 {
+		return g.Column(
+			g.Label(fmt.Sprintf("%s settings:", padLabel(activePadIndex))),
+			layersWidget,
+			createSampleWidget(),
+			createEffectsWidget(),
+			createTuningWidget(),
+			g.Dummy(30, 0),
+			createFormatWidget(),
+		)
+	}
+	cfg := layerSettings(activePadIndex, activeSynthLayer)
 	attack := float32(cfg.Attack)
 	decay := float32(cfg.Decay)
 	sustain := float32(cfg.Sustain)
@@ -499,93 +655,102 @@ func createSlidersForSelectedPad() g.Widget {
 		soundTypeStrings = append(soundTypeStrings, soundType.String())
 	}
 
-	soundTypeSelectedIndex := int32(pads[activePadIndex].SoundType)
+	soundTypeSelectedIndex := int32(cfg.SoundType)
 
 	return g.Column(
-		g.Label(fmt.Sprintf("Pad %d settings:", activePadIndex+1)),
-		g.Dummy(30, 0),
+		g.Label(fmt.Sprintf("%s settings:", padLabel(activePadIndex))),
+		layersWidget,
 		g.Row(
 			g.Label("Sound Type"),
-			g.Combo("Sound Type", pads[activePadIndex].SoundType.String(), soundTypeStrings, &soundTypeSelectedIndex).Size(150).OnChange(func() {
-				pads[activePadIndex] = synth.NewRandom(soundTypes[soundTypeSelectedIndex], nil, sampleRate, bitDepth, channels)
-				pads[activePadIndex].SoundType = soundTypes[soundTypeSelectedIndex]
+			g.Combo("Sound Type", cfg.SoundType.String(), soundTypeStrings, &soundTypeSelectedIndex).Size(150).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Sound Type", padLabel(activePadIndex)), activePadIndex)
+				layer := formatOf(cfg).newRandom(soundTypes[soundTypeSelectedIndex])
+				layer.SoundType = soundTypes[soundTypeSelectedIndex]
+				setLayerSettings(activePadIndex, activeSynthLayer, layer)
 			}),
 		),
 		g.Dummy(30, 0),
 		g.Row(
 			g.Label("Waveform"),
 			g.Combo("Waveform", waveforms[waveformSelectedIndex], waveforms, &waveformSelectedIndex).Size(150).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Waveform", padLabel(activePadIndex)), activePadIndex)
 				cfg.WaveformType = int(waveformSelectedIndex)
 			}),
 		),
 		g.Row(
 			g.Label("Attack"),
-			g.SliderFloat(&attack, 0.0, 1.0).Size(150).OnChange(func() { cfg.Attack = float64(attack) }),
+			g.SliderFloat(&attack, 0.0, 1.0).Size(150).OnChange(func() {
+				recordSliderEdit("Attack", activePadIndex)
+				cfg.Attack = float64(attack)
+			}),
 		),
 		g.Row(
 			g.Label("Decay"),
-			g.SliderFloat(&decay, 0.1, 1.0).Size(150).OnChange(func() { cfg.Decay = float64(decay) }),
+			g.SliderFloat(&decay, 0.1, 1.0).Size(150).OnChange(func() {
+				recordSliderEdit("Decay", activePadIndex)
+				cfg.Decay = float64(decay)
+			}),
 		),
 		g.Row(
 			g.Label("Sustain"),
-			g.SliderFloat(&sustain, 0.0, 1.0).Size(150).OnChange(func() { cfg.Sustain = float64(sustain) }),
+			g.SliderFloat(&sustain, 0.0, 1.0).Size(150).OnChange(func() {
+				recordSliderEdit("Sustain", activePadIndex)
+				cfg.Sustain = float64(sustain)
+			}),
 		),
 		g.Row(
 			g.Label("Release"),
-			g.SliderFloat(&release, 0.1, 1.0).Size(150).OnChange(func() { cfg.Release = float64(release) }),
+			g.SliderFloat(&release, 0.1, 1.0).Size(150).OnChange(func() {
+				recordSliderEdit("Release", activePadIndex)
+				cfg.Release = float64(release)
+			}),
 		),
 		g.Row(
 			g.Label("Drive"),
-			g.SliderFloat(&drive, 0.0, 1.0).Size(150).OnChange(func() { cfg.Drive = float64(drive) }),
+			g.SliderFloat(&drive, 0.0, 1.0).Size(150).OnChange(func() {
+				recordSliderEdit("Drive", activePadIndex)
+				cfg.Drive = float64(drive)
+			}),
 		),
 		g.Row(
 			g.Label("Filter Cutoff"),
-			g.SliderFloat(&filterCutoff, 1000, 8000).Size(150).OnChange(func() { cfg.FilterCutoff = float64(filterCutoff) }),
+			g.SliderFloat(&filterCutoff, 1000, 8000).Size(150).OnChange(func() {
+				recordSliderEdit("Filter Cutoff", activePadIndex)
+				cfg.FilterCutoff = float64(filterCutoff)
+			}),
 		),
 		g.Row(
 			g.Label("Sweep"),
-			g.SliderFloat(&sweep, 0.1, 2.0).Size(150).OnChange(func() { cfg.Sweep = float64(sweep) }),
-		),
-		g.Row(
-			g.Label("Pitch Decay"),
-			g.SliderFloat(&pitchDecay, 0.1, 1.5).Size(150).OnChange(func() { cfg.PitchDecay = float64(pitchDecay) }),
-		),
-		g.Dummy(30, 0),
-		g.Row(
-			g.Label("Sample Rate"),
-			g.Combo("Sample Rate", fmt.Sprintf("%d Hz", sampleRates[sampleRateIndex]), []string{
-				"44100 Hz", "48000 Hz", "96000 Hz", "192000 Hz",
-			}, &sampleRateIndex).Size(150).OnChange(func() {
-				sampleRate = sampleRates[sampleRateIndex]
+			g.SliderFloat(&sweep, 0.1, 2.0).Size(150).OnChange(func() {
+				recordSliderEdit("Sweep", activePadIndex)
+				cfg.Sweep = float64(sweep)
 			}),
 		),
 		g.Row(
-			g.Label("Bit Depth"),
-			g.Checkbox("24-bit instead of 16-bit", &bitDepthSelected).OnChange(func() {
-				if bitDepthSelected {
-					bitDepth = 24
-				} else {
-					bitDepth = 16
-				}
+			g.Label("Pitch Decay"),
+			g.SliderFloat(&pitchDecay, 0.1, 1.5).Size(150).OnChange(func() {
+				recordSliderEdit("Pitch Decay", activePadIndex)
+				cfg.PitchDecay = float64(pitchDecay)
 			}),
 		),
+		createEffectsWidget(),
+		createTuningWidget(),
+		g.Dummy(30, 0),
+		createFormatWidget(),
 		g.Dummy(30, 0),
 		g.Row(
 			g.Button("Play").OnClick(func() {
 				setStatusMessage("")
-				err := GeneratePlay(pads[activePadIndex])
+				err := GeneratePlay(pads[activePadIndex], padOpts[activePadIndex])
 				if err != nil {
 					setStatusMessage(fmt.Sprintf("Error: Failed to play %s.", padSoundTypes[activePadIndex]))
 				}
 			}),
 			g.Button("Randomize").OnClick(func() {
-				var randomSoundType synth.SoundType = synth.Kick
-				if rand.Float64() < 0.5 {
-					randomSoundType = synth.Snare
-				}
-				pads[activePadIndex] = synth.NewRandom(randomSoundType, nil, sampleRate, bitDepth, channels)
+				randomizePad(activePadIndex)
 			}),
 			g.Button("Randomize all").OnClick(func() {
+				recordEdit("Randomize all", allPadIndices()...)
 				randomizeAllPads()
 			}),
 		),
@@ -593,21 +758,82 @@ func createSlidersForSelectedPad() g.Widget {
 }
 
 func loop() {
+	applyQueued()
 	padGrid := []g.Widget{}
 	padIndex := 0
 	for row := 0; row < 4; row++ {
 		rowWidgets := []g.Widget{}
 		for col := 0; col < 4; col++ {
-			rowWidgets = append(rowWidgets, createPadWidget(pads[padIndex], fmt.Sprintf("Pad %d", padIndex+1), padIndex))
+			label := padLabel(padIndex)
+			if favorites[padIndex] {
+				label += " *"
+			}
+			if sample := padOpts[padIndex].Sample; sample != nil {
+				label += "\n" + sample.Name
+			}
+			if padOpts[padIndex].Mute {
+				label += " M"
+			} else if padOpts[padIndex].Solo {
+				label += " S"
+			}
+			rowWidgets = append(rowWidgets, createPadWidget(pads[padIndex], label, padIndex))
 			padIndex++
 		}
 		padGrid = append(padGrid, g.Row(rowWidgets...))
 	}
-	g.SingleWindow().Layout(
+	g.SingleWindowWithMenuBar().Layout(
+		g.MenuBar().Layout(
+			g.Menu("File").Layout(
+				g.MenuItem("Kit...").OnClick(func() {
+					pendingPopup = kitPopup
+				}),
+				g.MenuItem("Export...").OnClick(func() {
+					if len(selectedPads()) == 0 {
+						exportSelected[activePadIndex] = true
+					}
+					pendingPopup = exportPopup
+				}),
+				g.MenuItem("Hydrogen drumkit...").OnClick(func() {
+					pendingPopup = hydrogenPopup
+				}),
+				g.MenuItem("MIDI input...").OnClick(func() {
+					pendingPopup = midiPopup
+				}),
+				g.MenuItem("OSC server...").OnClick(func() {
+					pendingPopup = oscPopup
+				}),
+				g.Separator(),
+				g.MenuItem("Quit").OnClick(func() {
+					stopMixer()
+					os.Exit(0)
+				}),
+			),
+			g.Menu("Edit").Layout(
+				g.MenuItem("Undo").Shortcut(shortcut("undo")).Enabled(len(undoStack) > 0).OnClick(undo),
+				g.MenuItem("Redo").Shortcut(shortcut("redo")).Enabled(len(redoStack) > 0).OnClick(redo),
+				g.Separator(),
+				g.MenuItem("Copy pad").Shortcut(shortcut("copy")).OnClick(func() {
+					copyPad(activePadIndex)
+				}),
+				g.MenuItem("Paste pad").Shortcut(shortcut("paste")).OnClick(func() {
+					pastePad(activePadIndex)
+				}),
+				g.MenuItem("Duplicate to next free pad").Shortcut(shortcut("duplicate")).OnClick(func() {
+					duplicatePad(activePadIndex)
+				}),
+			),
+		),
 		g.Row(
 			g.Column(padGrid...),
 			g.Column(
-				createSlidersForSelectedPad(),
+				g.TabBar().ID("padTabs").TabItems(
+					g.TabItem("Sound").Layout(createSlidersForSelectedPad()),
+					g.TabItem("Variation").Layout(createVariationWidget()),
+					g.TabItem("Mixer").Layout(createMixerWidget()),
+					g.TabItem("Morph").Layout(createMorphWidget()),
+					g.TabItem("Breed").Layout(createBreedWidget()),
+					g.TabItem("History").Layout(createHistoryWidget()),
+				),
 				g.Dummy(30, 0),
 				g.Row(
 					g.InputText(&wavFilePath).Size(200),
@@ -618,16 +844,7 @@ func loop() {
 						}
 					}),
 					g.Button("Save").OnClick(func() {
-						pads[activePadIndex].SampleRate = sampleRate
-						pads[activePadIndex].BitDepth = bitDepth
-						fileName, err := pads[activePadIndex].GenerateAndSaveTo(".")
-						if err != nil {
-							setStatusMessage(fmt.Sprintf("Error: Failed to save %s to %s", padSoundTypes[activePadIndex], fileName))
-						} else {
-							os.Rename(fileName, wavFilePath)
-							fileName = wavFilePath
-							setStatusMessage(fmt.Sprintf("%s saved to %s", padSoundTypes[activePadIndex], fileName))
-						}
+						exportAndReport([]int{activePadIndex})
 					}),
 				),
 				g.Condition(len(loadedWaveform) > 0 || atomic.LoadInt32(&trainingOngoing) == 1,
@@ -639,18 +856,58 @@ func loop() {
 			),
 		),
 		g.Label(statusMessage),
+		g.Custom(func() {
+			if pendingPopup != "" {
+				g.OpenPopup(pendingPopup)
+				pendingPopup = ""
+			}
+		}),
+		exportPopupWidget(),
+		hydrogenPopupWidget(),
+		midiPopupWidget(),
+		oscPopupWidget(),
+		kitPopupWidget(),
+		restorePopupWidget(),
 	)
 }
 
+// toggleTraining starts training the active pad on the loaded WAV, or stops the training if it is ongoing
+func toggleTraining() {
+	if atomic.LoadInt32(&trainingOngoing) == 1 {
+		select {
+		case <-cancelTraining: // already canceled, waiting for the current generation to finish
+		default:
+			close(cancelTraining)
+		}
+		return
+	}
+	if len(loadedWaveform) == 0 {
+		setStatusMessage("Error: No .wav file loaded. Please load a .wav file first.")
+		return
+	}
+	if padOpts[activePadIndex].Sample != nil {
+		setStatusMessage(fmt.Sprintf("Error: %s plays a sample, only synthesized pads can be trained", padLabel(activePadIndex)))
+		return
+	}
+	recordEdit(fmt.Sprintf("%s: Training", padLabel(activePadIndex)), activePadIndex)
+	trainingPadIndex = activePadIndex
+	trainingLayer = activeSynthLayer
+	cancelTraining = make(chan struct{})
+	atomic.StoreInt32(&trainingOngoing, 1)
+	const allWaveforms = true
+	opts := padOpts[trainingPadIndex].clone()
+	sound := opts.sound().withMainLayer(trainingLayer, synth.CopySettings(pads[trainingPadIndex]))
+	if !trainWithEffects {
+		sound.Effects = padEffects{}
+	}
+	go optimizeSettings(allWaveforms, trainLoudnessInvariant, sound, formatOf(pads[trainingPadIndex]))
+}
+
 func generateTrainingButtons() g.Widget {
 	if len(loadedWaveform) > 0 {
 		if atomic.LoadInt32(&trainingOngoing) == 1 {
 			return g.Row(
-				g.Button("Stop training").OnClick(func() {
-					if atomic.LoadInt32(&trainingOngoing) == 1 {
-						close(cancelTraining)
-					}
-				}),
+				g.Button("Stop training").OnClick(toggleTraining),
 				g.Button("Play WAV").OnClick(func() {
 					err := playLoadedWaveform()
 					if err != nil {
@@ -660,21 +917,22 @@ func generateTrainingButtons() g.Widget {
 			)
 		}
 		return g.Row(
-			g.Button("Find sound similar to WAV").OnClick(func() {
-				if atomic.LoadInt32(&trainingOngoing) == 0 {
-					cancelTraining = make(chan struct{})
-					atomic.StoreInt32(&trainingOngoing, 1)
-					const allWaveforms = true
-					go optimizeSettings(allWaveforms)
-				}
-			}),
+			g.Button("Find sound similar to WAV").OnClick(toggleTraining),
+			g.Checkbox("With effects", &trainWithEffects),
+			g.Checkbox("Ignore loudness", &trainLoudnessInvariant),
 			g.Button("Play WAV").OnClick(func() {
 				err := playLoadedWaveform()
 				if err != nil {
 					setStatusMessage("Error: Failed to play WAV")
 				}
 			}),
+			g.Button("Put on pad").OnClick(func() {
+				setPadSample(activePadIndex, loadedWaveform, loadedWaveformName)
+			}),
+			g.Tooltip("Drag onto a pad, or click to put the WAV on the active pad"),
+			sampleDragSource(),
 			g.Button("Quit").OnClick(func() {
+				stopMixer()
 				os.Exit(0)
 			}),
 		)
@@ -682,34 +940,96 @@ func generateTrainingButtons() g.Widget {
 	return g.Dummy(0, 0)
 }
 
-func GeneratePlay(cfg *synth.Settings) error {
-	muPlayer.Lock()
-	defer muPlayer.Unlock()
-	if player == nil || !player.Initialized {
-		return errors.New("audio player is not initialized")
+// GeneratePlay plays the sound of the settings as it is exported, with the other layers, the effects and,
+// for stereo settings, the width and the pan of the given options. The sound chokes the pads in the choke group of the options.
+func GeneratePlay(cfg *synth.Settings, opts padOptions) error {
+	v := &voice{padIndex: -1, chokeGroup: opts.ChokeGroup}
+	v.left, v.right = panGains(0)
+	var err error
+	if cfg.Channels == 2 {
+		v.samples, v.rightSamples, err = opts.stereoChannels(cfg, opts.sound(), mixerSampleRate)
+		v.left, v.right = panGains(opts.Pan)
+	} else {
+		v.samples, err = renderSound(cfg, opts.sound(), mixerSampleRate)
 	}
-	samples, err := cfg.Generate()
 	if err != nil {
 		return err
 	}
-	return player.PlayWaveform(samples, cfg.SampleRate, cfg.BitDepth, cfg.Channels)
+	audioMixer.trigger(v)
+	return nil
+}
+
+// initPads fills all pads with random kicks
+func initPads() {
+	const defaultSoundType = synth.Kick
+	for i := 0; i < numPads; i++ {
+		pads[i] = defaultFormat.newRandom(defaultSoundType)
+		padOpts[i] = newPadOptions()
+	}
+	activePadIndex = 0
+}
+
+// isFlagSet returns true if the flag with the given name was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func main() {
-	player = playsample.NewPlayer()
-	if !player.Initialized {
-		log.Fatalln("Error: Audio Player failed to initialize.")
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(os.Args[2:]); err != nil {
+			log.Fatalln("Error:", err)
+		}
+		return
+	}
+	oscListen := flag.String("osc", "", "listen for OSC messages on this UDP address, like :9000")
+	flag.StringVar(&oscSendAddress, "osc-send", "", "send the training progress as OSC messages to this UDP address, like 127.0.0.1:9001")
+	audio := flag.String("audio", defaultAudioOutput, fmt.Sprintf("the audio output, one of %v", audioOutputs))
+	recordPath := flag.String("record", defaultRecordPath, "the WAV file that the wav audio output records to")
+	flag.Parse()
+	if *audio == defaultAudioOutput && isFlagSet("record") {
+		*audio = "wav"
 	}
-	defer player.Close()
 	err := loadWavData(kick909Wav)
 	if err != nil {
 		log.Fatalln("Error loading embedded .wav data:", err)
 	}
-	const defaultSoundType = synth.Kick
-	for i := 0; i < numPads; i++ {
-		pads[i] = synth.NewRandom(defaultSoundType, nil, sampleRate, bitDepth, channels)
-	}
-	activePadIndex = 0
+	initPads()
 	setStatusMessage(versionString)
-	g.NewMasterWindow(versionString, 780, 445, g.MasterWindowFlagsNotResizable).Run(loop)
+	if err := startMixer(*audio, *recordPath); err != nil {
+		setStatusMessage(fmt.Sprintf("Error: %v", err))
+	}
+	defer stopMixer()
+	wnd := g.NewMasterWindow(versionString, 780, 495, g.MasterWindowFlagsNotResizable)
+	if keysPath, err := configPath("keys.json"); err == nil {
+		if keys, err = loadKeyBindings(keysPath); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Failed to load key bindings: %v", err))
+		}
+	}
+	if err := registerKeyBindings(wnd, keys); err != nil {
+		setStatusMessage(fmt.Sprintf("Error: Invalid key bindings: %v", err))
+	}
+	if midiPath, err := configPath("midi.json"); err == nil {
+		if midiMap, err = loadMidiMapping(midiPath); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Failed to load the MIDI mapping: %v", err))
+		}
+	}
+	if devices := midiDevices(); len(devices) > 0 {
+		midiDevice = devices[0]
+	}
+	if virtualMidiPortAvailable {
+		startMidi("the Kickpad virtual port", openVirtualMidiPort)
+	}
+	if *oscListen != "" {
+		oscAddress = *oscListen
+		if err := startOSC(oscAddress, oscSendAddress); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Failed to start the OSC server: %v", err))
+		}
+	}
+	wnd.Run(loop)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	// metadataChunkID is the ID of the RIFF chunk that is appended to saved WAV files.
+	// Other applications skip chunks they do not know, so the files can still be read normally.
+	metadataChunkID = "kpad"
+	restorePopup    = "Restore settings"
+)
+
+// wavMetadata is stored as JSON in the metadata chunk of saved WAV files
+type wavMetadata struct {
+	Version  string
+	Options  padOptions
+	Settings storedSettings
+}
+
+var pendingMetadata *wavMetadata
+
+func embedSettings(filePath string, cfg *synth.Settings, opts padOptions) error {
+	data, err := json.Marshal(wavMetadata{
+		Version:  versionString,
+		Options:  opts,
+		Settings: storeSettings(cfg),
+	})
+	if err != nil {
+		return err
+	}
+	return appendRiffChunk(filePath, metadataChunkID, data)
+}
+
+// readWavMetadata returns the settings that are embedded in the given WAV file, or nil if there are none
+func readWavMetadata(filePath string) (*wavMetadata, error) {
+	data, err := readRiffChunk(filePath, metadataChunkID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var meta wavMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("could not read the settings in %s: %v", filePath, err)
+	}
+	if err := validSettings(meta.Settings); err != nil {
+		return nil, fmt.Errorf("invalid settings in %s: %v", filePath, err)
+	}
+	return &meta, nil
+}
+
+// appendRiffChunk adds a chunk to the end of a RIFF file and updates the size in the RIFF header
+func appendRiffChunk(filePath, id string, data []byte) error {
+	if len(id) != 4 {
+		return fmt.Errorf("invalid RIFF chunk ID: %q", id)
+	}
+	f, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:4]) != "RIFF" {
+		return fmt.Errorf("%s is not a RIFF file", filePath)
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if end%2 == 1 { // chunks start at even offsets
+		buf.WriteByte(0)
+	}
+	buf.WriteString(id)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	riffSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(riffSize, uint32(end+int64(buf.Len())-8))
+	if _, err := f.WriteAt(riffSize, 4); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readRiffChunk returns the contents of the first chunk with the given ID, or nil if there is no such chunk
+func readRiffChunk(filePath, id string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	for pos := 12; pos+8 <= len(data); {
+		chunkID := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if size > len(data)-pos {
+			break
+		}
+		if chunkID == id {
+			return data[pos : pos+size], nil
+		}
+		pos += size + size%2
+	}
+	return nil, nil
+}
+
+func restoreMetadata(padIndex int, meta *wavMetadata) {
+	pads[padIndex] = meta.Settings.settings()
+	if meta.Options.VelocityLayers > 0 && meta.Options.RoundRobin > 0 {
+		padOpts[padIndex] = meta.Options.clone()
+		padOpts[padIndex].Effects.setDefaults()
+	}
+	roundRobinIndex[padIndex] = 0
+}
+
+func restorePopupWidget() g.Widget {
+	return g.PopupModal(restorePopup).Layout(
+		g.Custom(func() {
+			if pendingMetadata == nil {
+				g.CloseCurrentPopup()
+				return
+			}
+			g.Label(fmt.Sprintf("%s contains settings from %s.", wavFilePath, pendingMetadata.Version)).Build()
+			g.Label(fmt.Sprintf("Restore the %s into %s, or use the file as the target?", pendingMetadata.Settings.SoundType, padLabel(activePadIndex))).Build()
+		}),
+		g.Row(
+			g.Button("Restore settings").OnClick(func() {
+				if pendingMetadata != nil {
+					recordEdit(fmt.Sprintf("%s: Restore settings", padLabel(activePadIndex)), activePadIndex)
+					restoreMetadata(activePadIndex, pendingMetadata)
+					setStatusMessage(fmt.Sprintf("Restored the settings from %s into %s", wavFilePath, padLabel(activePadIndex)))
+				}
+				pendingMetadata = nil
+				g.CloseCurrentPopup()
+			}),
+			g.Button("Use as target").OnClick(func() {
+				pendingMetadata = nil
+				g.CloseCurrentPopup()
+			}),
+		),
+	)
+}
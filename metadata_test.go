@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xyproto/synth"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "kick.wav")
+	if err := writeWav(filePath, []float64{0, 0.5, -0.5, 0}, 44100, 16, 1); err != nil {
+		t.Fatal(err)
+	}
+	cfg := synth.NewRandom(synth.Kick, nil, 44100, 16, 1)
+	opts := newPadOptions()
+	opts.Gain = -3
+	if err := embedSettings(filePath, cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := readWavMetadata(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil {
+		t.Fatal("the settings were not found")
+	}
+	if !reflect.DeepEqual(meta.Settings, storeSettings(cfg)) {
+		t.Fatalf("got %+v, not %+v", meta.Settings, storeSettings(cfg))
+	}
+	if meta.Options.Gain != -3 || meta.Options.Seed != opts.Seed {
+		t.Fatalf("got %+v", meta.Options)
+	}
+}
+
+func TestMetadataInvalid(t *testing.T) {
+	for name, chunk := range map[string]string{
+		"no channels": `{"Settings": {"SampleRate": 44100, "BitDepth": 16, "Channels": 0, "Duration": 1}}`,
+		"empty":       `{}`,
+		"truncated":   `{"Settings": {"SampleRate": 44100, "BitDepth": 16, "Chan`,
+	} {
+		filePath := filepath.Join(t.TempDir(), "kick.wav")
+		if err := writeWav(filePath, []float64{0, 0.5}, 44100, 16, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendRiffChunk(filePath, metadataChunkID, []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if meta, err := readWavMetadata(filePath); err == nil {
+			t.Errorf("%s: got %+v", name, meta)
+		}
+	}
+}
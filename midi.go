@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	midiNoteOn        = 0x90
+	midiControlChange = 0xb0
+
+	// firstPadNote is the note of the first pad, the General MIDI bass drum. The other pads follow chromatically.
+	firstPadNote = 36
+
+	midiPopup = "MIDI input"
+)
+
+type midiMessage struct {
+	Status byte
+	Data1  byte
+	Data2  byte
+}
+
+// command returns the type of message, without the channel
+func (m midiMessage) command() byte {
+	return m.Status & 0xf0
+}
+
+// midiParser turns a stream of MIDI bytes into messages, with support for running status
+type midiParser struct {
+	status byte
+	data   [2]byte
+	n      int
+	sysex  bool
+}
+
+func midiDataLength(status byte) int {
+	switch status & 0xf0 {
+	case 0xc0, 0xd0: // program change and channel pressure
+		return 1
+	}
+	return 2
+}
+
+// feed adds one byte to the parser, and returns a message when one is complete
+func (p *midiParser) feed(b byte) (midiMessage, bool) {
+	switch {
+	case b >= 0xf8: // real-time messages can appear anywhere, and are ignored
+		return midiMessage{}, false
+	case b == 0xf0:
+		p.sysex = true
+		p.status = 0
+		return midiMessage{}, false
+	case b >= 0xf0: // other system messages, including the end of a sysex, cancel the running status
+		p.sysex = false
+		p.status = 0
+		return midiMessage{}, false
+	case b >= 0x80:
+		p.sysex = false
+		p.status = b
+		p.n = 0
+		return midiMessage{}, false
+	}
+	if p.sysex || p.status == 0 {
+		return midiMessage{}, false
+	}
+	p.data[p.n] = b
+	p.n++
+	if p.n < midiDataLength(p.status) {
+		return midiMessage{}, false
+	}
+	p.n = 0
+	return midiMessage{Status: p.status, Data1: p.data[0], Data2: p.data[1]}, true
+}
+
+// midiMapping is the note that plays each pad, and the CC number that controls each parameter of the active pad
+type midiMapping struct {
+	Notes    [numPads]int
+	Controls map[string]int
+}
+
+func defaultMidiMapping() midiMapping {
+	m := midiMapping{Controls: make(map[string]int)}
+	for i := range m.Notes {
+		m.Notes[i] = firstPadNote + i
+	}
+	return m
+}
+
+var (
+	midiMap = defaultMidiMapping()
+
+	// midiLearnPad is the pad that the next note is assigned to, or -1
+	midiLearnPad = -1
+	// midiLearnParameter is the parameter that the next CC is assigned to, or an empty string
+	midiLearnParameter string
+	midiParameterIndex int32
+
+	midiDevice string
+	midiStatus = "Not connected"
+	midiInput  io.Closer
+	midiMu     sync.Mutex
+)
+
+func loadMidiMapping(filePath string) (midiMapping, error) {
+	m := defaultMidiMapping()
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return defaultMidiMapping(), fmt.Errorf("%s: %v", filePath, err)
+	}
+	if m.Controls == nil {
+		m.Controls = make(map[string]int)
+	}
+	return m, nil
+}
+
+// saveMidiMapping stores the MIDI mapping in the configuration directory, so that learned notes and CCs are kept
+func saveMidiMapping() error {
+	filePath, err := configPath("midi.json")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(midiMap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+func setPadParameter(padIndex int, p padParameter, value float64) {
+	recordSliderEdit(p.name, padIndex)
+	*p.field(pads[padIndex]) = p.min + value*(p.max-p.min)
+}
+
+// learned is called when a note or CC has been learned
+func learned(msg string) {
+	if err := saveMidiMapping(); err != nil {
+		msg += fmt.Sprintf(", but the mapping could not be saved: %v", err)
+	}
+	setStatusMessage(msg)
+}
+
+// handleMidiMessage plays pads for note on messages, and changes the parameters of the active pad for CC messages
+func handleMidiMessage(msg midiMessage) {
+	switch msg.command() {
+	case midiNoteOn:
+		if msg.Data2 == 0 { // a note on with velocity 0 is a note off
+			return
+		}
+		note := int(msg.Data1)
+		if midiLearnPad >= 0 {
+			for i, padNote := range midiMap.Notes {
+				if padNote == note {
+					midiMap.Notes[i] = -1
+				}
+			}
+			midiMap.Notes[midiLearnPad] = note
+			learned(fmt.Sprintf("Note %d now plays %s", note, padLabel(midiLearnPad)))
+			midiLearnPad = -1
+			break
+		}
+		for padIndex, padNote := range midiMap.Notes {
+			if padNote != note {
+				continue
+			}
+			activePadIndex = padIndex
+			play := playPad(padIndex, float64(msg.Data2)/127)
+			go func() {
+				if err := play(); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to play sound: %v", err))
+				}
+			}()
+		}
+	case midiControlChange:
+		control := int(msg.Data1)
+		if midiLearnParameter != "" {
+			for name, c := range midiMap.Controls {
+				if c == control {
+					delete(midiMap.Controls, name)
+				}
+			}
+			midiMap.Controls[midiLearnParameter] = control
+			learned(fmt.Sprintf("CC %d now controls %s", control, midiLearnParameter))
+			midiLearnParameter = ""
+			break
+		}
+		for _, p := range padParameters {
+			if c, ok := midiMap.Controls[p.name]; ok && c == control {
+				setPadParameter(activePadIndex, p, float64(msg.Data2)/127)
+			}
+		}
+	}
+}
+
+// readMidi handles the MIDI messages from the given input until it is closed
+func readMidi(r io.Reader) error {
+	var parser midiParser
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if msg, ok := parser.feed(b); ok {
+			runOnUI(func() { handleMidiMessage(msg) })
+		}
+	}
+}
+
+func setMidiStatus(msg string) {
+	midiMu.Lock()
+	defer midiMu.Unlock()
+	midiStatus = msg
+}
+
+// startMidi closes the current MIDI input and starts reading from the given one in the background
+func startMidi(name string, open func() (io.ReadCloser, error)) {
+	stopMidi()
+	setMidiStatus(fmt.Sprintf("Opening %s...", name))
+	go func() {
+		// opening a FIFO blocks until something is written to it, so this is done in the background
+		input, err := open()
+		if err != nil {
+			setMidiStatus(fmt.Sprintf("Error: %v", err))
+			return
+		}
+		midiMu.Lock()
+		midiInput = input
+		midiStatus = fmt.Sprintf("Listening on %s", name)
+		midiMu.Unlock()
+		refreshWindow()
+		err = readMidi(input)
+		midiMu.Lock()
+		defer midiMu.Unlock()
+		if midiInput == input {
+			midiInput = nil
+			if errors.Is(err, io.EOF) {
+				midiStatus = fmt.Sprintf("%s was closed", name)
+			} else {
+				midiStatus = fmt.Sprintf("Error: %v", err)
+			}
+			input.Close()
+		}
+		refreshWindow()
+	}()
+}
+
+func stopMidi() {
+	midiMu.Lock()
+	defer midiMu.Unlock()
+	if midiInput != nil {
+		midiInput.Close()
+		midiInput = nil
+	}
+	midiStatus = "Not connected"
+}
+
+// midiDevices returns the raw MIDI devices, like /dev/snd/midiC1D0 on Linux
+func midiDevices() []string {
+	var devices []string
+	for _, pattern := range []string{"/dev/snd/midiC*D*", "/dev/midi*"} {
+		matches, _ := filepath.Glob(pattern)
+		devices = append(devices, matches...)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+func midiPopupWidget() g.Widget {
+	var noteRows []g.Widget
+	for row := 0; row < 4; row++ {
+		var labels []g.Widget
+		for col := 0; col < 4; col++ {
+			padIndex := row*4 + col
+			note := "-"
+			if midiMap.Notes[padIndex] >= 0 {
+				note = fmt.Sprint(midiMap.Notes[padIndex])
+			}
+			labels = append(labels, g.Label(fmt.Sprintf("%2d: %-4s", padIndex+1, note)))
+		}
+		noteRows = append(noteRows, g.Row(labels...))
+	}
+	var parameterNames []string
+	var controlLabels []g.Widget
+	for _, p := range padParameters {
+		parameterNames = append(parameterNames, p.name)
+		control := "-"
+		if c, ok := midiMap.Controls[p.name]; ok {
+			control = fmt.Sprintf("CC %d", c)
+		}
+		controlLabels = append(controlLabels, g.Label(fmt.Sprintf("%s: %s", p.name, control)))
+	}
+	learnNote := fmt.Sprintf("Learn note for %s", padLabel(activePadIndex))
+	if midiLearnPad >= 0 {
+		learnNote = fmt.Sprintf("Waiting for a note for %s...", padLabel(midiLearnPad))
+	}
+	learnControl := "Learn CC"
+	if midiLearnParameter != "" {
+		learnControl = fmt.Sprintf("Waiting for a CC for %s...", midiLearnParameter)
+	}
+	midiMu.Lock()
+	status := midiStatus
+	midiMu.Unlock()
+	return g.PopupModal(midiPopup).Layout(
+		g.Row(
+			g.Label("Device"),
+			g.InputText(&midiDevice).Size(200).Hint("/dev/snd/midiC1D0"),
+			g.Button("Open").OnClick(func() {
+				device := midiDevice
+				startMidi(device, func() (io.ReadCloser, error) {
+					return os.Open(device)
+				})
+			}),
+			// builds without a virtual port say why in the status, see openVirtualMidiPort
+			g.Button("Virtual port").OnClick(func() {
+				startMidi("the Kickpad virtual port", openVirtualMidiPort)
+			}),
+			g.Button("Disconnect").OnClick(stopMidi),
+		),
+		g.Label(status),
+		g.Dummy(30, 0),
+		g.Label("Notes that play the pads:"),
+		g.Column(noteRows...),
+		g.Row(
+			g.Button(learnNote).OnClick(func() {
+				midiLearnPad = activePadIndex
+				midiLearnParameter = ""
+			}),
+			g.Button("Default notes").OnClick(func() {
+				midiMap.Notes = defaultMidiMapping().Notes
+				learned("The pads are played by the default notes")
+			}),
+		),
+		g.Dummy(30, 0),
+		g.Label("CCs that control the active pad:"),
+		g.Column(controlLabels...),
+		g.Row(
+			g.Combo("##midiParameter", parameterNames[midiParameterIndex], parameterNames, &midiParameterIndex).Size(150),
+			g.Button(learnControl).OnClick(func() {
+				midiLearnParameter = parameterNames[midiParameterIndex]
+				midiLearnPad = -1
+			}),
+			g.Button("Forget").OnClick(func() {
+				delete(midiMap.Controls, parameterNames[midiParameterIndex])
+				learned(fmt.Sprintf("%s is no longer controlled by MIDI", parameterNames[midiParameterIndex]))
+			}),
+		),
+		g.Dummy(30, 0),
+		g.Button("Close").OnClick(func() {
+			midiLearnPad = -1
+			midiLearnParameter = ""
+			g.CloseCurrentPopup()
+		}),
+	)
+}
@@ -0,0 +1,118 @@
+//go:build linux && cgo && alsa
+
+package main
+
+/*
+#cgo LDFLAGS: -lasound
+#include <alsa/asoundlib.h>
+
+// kickpad_open opens the ALSA sequencer without blocking, and creates a port that other clients can connect to
+static int kickpad_open(snd_seq_t **seq) {
+	int err = snd_seq_open(seq, "default", SND_SEQ_OPEN_INPUT, SND_SEQ_NONBLOCK);
+	if (err < 0) {
+		return err;
+	}
+	snd_seq_set_client_name(*seq, "Kickpad");
+	err = snd_seq_create_simple_port(*seq, "Kickpad MIDI In",
+		SND_SEQ_PORT_CAP_WRITE | SND_SEQ_PORT_CAP_SUBS_WRITE,
+		SND_SEQ_PORT_TYPE_MIDI_GENERIC | SND_SEQ_PORT_TYPE_APPLICATION);
+	if (err < 0) {
+		snd_seq_close(*seq);
+		return err;
+	}
+	return 0;
+}
+
+// kickpad_read reads one event, and converts note and controller events to MIDI bytes.
+// It returns the number of bytes, 0 for other events, or a negative error code.
+static int kickpad_read(snd_seq_t *seq, unsigned char *msg) {
+	snd_seq_event_t *ev;
+	int err = snd_seq_event_input(seq, &ev);
+	if (err < 0) {
+		return err;
+	}
+	switch (ev->type) {
+	case SND_SEQ_EVENT_NOTEON:
+	case SND_SEQ_EVENT_NOTEOFF:
+		msg[0] = (ev->type == SND_SEQ_EVENT_NOTEON ? 0x90 : 0x80) | (ev->data.note.channel & 0x0f);
+		msg[1] = ev->data.note.note & 0x7f;
+		msg[2] = ev->data.note.velocity & 0x7f;
+		return 3;
+	case SND_SEQ_EVENT_CONTROLLER:
+		msg[0] = 0xb0 | (ev->data.control.channel & 0x0f);
+		msg[1] = ev->data.control.param & 0x7f;
+		msg[2] = ev->data.control.value & 0x7f;
+		return 3;
+	}
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const virtualMidiPortAvailable = true
+
+// alsaMidiInput is an ALSA sequencer port, that note and controller events can be read from as MIDI bytes
+type alsaMidiInput struct {
+	mu      sync.Mutex
+	seq     *C.snd_seq_t
+	pending []byte
+}
+
+// openVirtualMidiPort creates a "Kickpad" ALSA sequencer client, that MIDI controllers and sequencers
+// can be connected to with for example aconnect, without any MIDI hardware being present
+func openVirtualMidiPort() (io.ReadCloser, error) {
+	var seq *C.snd_seq_t
+	if err := C.kickpad_open(&seq); err < 0 {
+		return nil, fmt.Errorf("could not open the ALSA sequencer: %s", C.GoString(C.snd_strerror(err)))
+	}
+	return &alsaMidiInput{seq: seq}, nil
+}
+
+func (in *alsaMidiInput) Read(p []byte) (int, error) {
+	var msg [3]C.uchar
+	for len(in.pending) == 0 {
+		in.mu.Lock()
+		if in.seq == nil {
+			in.mu.Unlock()
+			return 0, io.EOF
+		}
+		n := C.kickpad_read(in.seq, &msg[0])
+		in.mu.Unlock()
+		switch {
+		case n == -C.EAGAIN:
+			time.Sleep(2 * time.Millisecond)
+		case n == -C.ENOSPC: // the input buffer overran and events were lost, but reading can continue
+		case n < 0:
+			return 0, errors.New(C.GoString(C.snd_strerror(n)))
+		default:
+			for i := 0; i < int(n); i++ {
+				in.pending = append(in.pending, byte(msg[i]))
+			}
+		}
+	}
+	n := copy(p, in.pending)
+	in.pending = in.pending[n:]
+	return n, nil
+}
+
+func (in *alsaMidiInput) Close() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.seq == nil {
+		return nil
+	}
+	err := C.snd_seq_close(in.seq)
+	in.seq = nil
+	if err < 0 {
+		return errors.New(C.GoString(C.snd_strerror(err)))
+	}
+	return nil
+}
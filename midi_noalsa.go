@@ -0,0 +1,14 @@
+//go:build !(linux && cgo && alsa)
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+const virtualMidiPortAvailable = false
+
+func openVirtualMidiPort() (io.ReadCloser, error) {
+	return nil, errors.New("this build of Kickpad has no virtual MIDI port, build it with -tags alsa on Linux, or open a raw MIDI device")
+}
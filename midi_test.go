@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func feedMidi(bytes ...byte) []midiMessage {
+	var parser midiParser
+	var messages []midiMessage
+	for _, b := range bytes {
+		if msg, ok := parser.feed(b); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func TestMidiParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes []byte
+		want  []midiMessage
+	}{
+		{"note on", []byte{0x90, 36, 100}, []midiMessage{{0x90, 36, 100}}},
+		{"running status", []byte{0x91, 36, 100, 38, 90, 38, 0}, []midiMessage{{0x91, 36, 100}, {0x91, 38, 90}, {0x91, 38, 0}}},
+		{"real-time bytes within a message", []byte{0x90, 0xf8, 36, 0xfe, 100}, []midiMessage{{0x90, 36, 100}}},
+		{"sysex cancels the running status", []byte{0x90, 36, 100, 0xf0, 1, 2, 3, 0xf7, 38, 90}, []midiMessage{{0x90, 36, 100}}},
+		{"program change has one data byte", []byte{0xc0, 5, 6}, []midiMessage{{0xc0, 5, 0}, {0xc0, 6, 0}}},
+		{"data without a status", []byte{36, 100, 0xb0, 7, 64}, []midiMessage{{0xb0, 7, 64}}},
+	}
+	for _, test := range tests {
+		if got := feedMidi(test.bytes...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, not %v", test.name, got, test.want)
+		}
+	}
+}
+
+// setUpMidiTest starts with new pads, that are muted so that nothing is played, and the default MIDI mapping
+func setUpMidiTest(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	initPads()
+	for i := range padOpts {
+		padOpts[i].Mute = true
+	}
+	midiMap = defaultMidiMapping()
+	t.Cleanup(func() {
+		midiMap = defaultMidiMapping()
+		midiLearnPad, midiLearnParameter = -1, ""
+	})
+}
+
+func TestMidiNotes(t *testing.T) {
+	setUpMidiTest(t)
+	handleMidiMessage(midiMessage{midiNoteOn, firstPadNote + 2, 100})
+	if activePadIndex != 2 {
+		t.Fatalf("note on played pad %d, not pad 2", activePadIndex)
+	}
+	// a note on with velocity 0 is a note off
+	handleMidiMessage(midiMessage{midiNoteOn, firstPadNote + 5, 0})
+	if activePadIndex != 2 {
+		t.Fatalf("note off played pad %d", activePadIndex)
+	}
+	midiLearnPad = 7
+	handleMidiMessage(midiMessage{midiNoteOn, firstPadNote + 2, 100})
+	if midiMap.Notes[7] != firstPadNote+2 || midiMap.Notes[2] != -1 || midiLearnPad != -1 {
+		t.Fatalf("the learned note was not moved to pad 7: %v", midiMap.Notes)
+	}
+}
+
+func TestMidiControls(t *testing.T) {
+	setUpMidiTest(t)
+	var drive padParameter
+	for _, p := range padParameters {
+		if p.name == "Drive" {
+			drive = p
+		}
+	}
+	midiLearnParameter = drive.name
+	handleMidiMessage(midiMessage{midiControlChange, 20, 0})
+	if midiMap.Controls[drive.name] != 20 || midiLearnParameter != "" {
+		t.Fatalf("CC 20 was not learned: %v", midiMap.Controls)
+	}
+	handleMidiMessage(midiMessage{midiControlChange, 20, 127})
+	if got := *drive.field(pads[activePadIndex]); got != drive.max {
+		t.Fatalf("CC 20 set %s to %f, not %f", drive.name, got, drive.max)
+	}
+	handleMidiMessage(midiMessage{midiControlChange, 21, 0})
+	if got := *drive.field(pads[activePadIndex]); got != drive.max {
+		t.Fatalf("an unmapped CC changed %s to %f", drive.name, got)
+	}
+}
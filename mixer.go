@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	// mixerSampleRate is the sample rate of the stream to the audio device. Pads with other sample rates are resampled.
+	mixerSampleRate = 44100
+	// mixerBlockSize is the number of frames that are mixed at a time
+	mixerBlockSize = 256
+	// mixerLatency is how far ahead of the audio device the mixer stays
+	mixerLatency = 50 * time.Millisecond
+
+	maxVoices      = 32
+	maxChokeGroups = 8
+	minPadGain     = -24.0
+	maxPadGain     = 6.0
+
+	// chokeFadeFrames is how long a choked voice takes to fade out (5 ms), to avoid clicks
+	chokeFadeFrames = mixerSampleRate / 200
+	// limiterThreshold is the highest output level, and limiterRelease is how fast the limiter lets go again, in seconds
+	limiterThreshold = 0.98
+	limiterRelease   = 0.1
+	// meterRelease is how fast the output meter falls, in seconds, and meterRefresh is how often it is redrawn
+	meterRelease = 0.3
+	meterRefresh = 50 * time.Millisecond
+)
+
+// voice is one sound that is playing
+type voice struct {
+	padIndex   int
+	chokeGroup int
+	samples    []float64
+	// rightSamples is the right channel of stereo voices, which play samples in the left channel
+	rightSamples []float64
+	pos          int
+	left, right  float64
+	// fade is the number of frames left of the fade out, after the voice has been choked or stopped
+	fade int
+}
+
+// mixer mixes the voices that are playing into one stereo stream, with a limiter on the output
+type mixer struct {
+	mu          sync.Mutex
+	voices      []*voice
+	limiterGain float64
+	// meterPeak and meterRMS are the level of the output, for the meter
+	meterPeak float64
+	meterRMS  float64
+}
+
+var audioMixer = &mixer{limiterGain: 1}
+
+// trigger starts playing a voice. A new voice chokes the other voices in the same choke group,
+// and the oldest voice that is not already fading out is stopped if all voices are in use.
+func (m *mixer) trigger(v *voice) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v.chokeGroup > 0 {
+		for _, other := range m.voices {
+			if other.chokeGroup == v.chokeGroup {
+				other.stop()
+			}
+		}
+	}
+	// the stolen voice fades out like a choked voice, to avoid a click, and is removed by mix when the fade is done
+	playing := 0
+	for _, other := range m.voices {
+		if other.fade == 0 {
+			playing++
+		}
+	}
+	if playing >= maxVoices {
+		for _, other := range m.voices {
+			if other.fade == 0 {
+				other.stop()
+				break
+			}
+		}
+	}
+	m.voices = append(m.voices, v)
+}
+
+// stopPad fades out the voices of a pad, or all voices for -1
+func (m *mixer) stopPad(padIndex int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.voices {
+		if padIndex < 0 || v.padIndex == padIndex {
+			v.stop()
+		}
+	}
+}
+
+func (v *voice) stop() {
+	if v.fade == 0 || v.fade > chokeFadeFrames {
+		v.fade = chokeFadeFrames
+	}
+}
+
+// status returns the number of voices that are playing and the current gain reduction of the limiter, in dB
+func (m *mixer) status() (int, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.voices), 20 * math.Log10(m.limiterGain)
+}
+
+// levels returns the peak and RMS level of the output, in dB
+func (m *mixer) levels() (float64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return decibels(m.meterPeak), decibels(m.meterRMS)
+}
+
+// mix fills out with the next frames of all voices, and removes the voices that have finished
+func (m *mixer) mix(out []float32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	release := 1 - math.Exp(-1/(limiterRelease*mixerSampleRate))
+	peak, sum := 0.0, 0.0
+	for frame := 0; frame < len(out)/2; frame++ {
+		var left, right float64
+		for _, v := range m.voices {
+			if v.pos >= len(v.samples) {
+				continue
+			}
+			sample, rightSample := v.samples[v.pos], v.samples[v.pos]
+			if v.rightSamples != nil {
+				rightSample = v.rightSamples[v.pos]
+			}
+			v.pos++
+			if v.fade > 0 {
+				fade := float64(v.fade) / chokeFadeFrames
+				sample *= fade
+				rightSample *= fade
+				v.fade--
+				if v.fade == 0 {
+					v.pos = len(v.samples)
+				}
+			}
+			left += sample * v.left
+			right += rightSample * v.right
+		}
+		// the gain is slowly raised again, but lowered right away when a peak would go over the threshold
+		m.limiterGain += (1 - m.limiterGain) * release
+		if peak := math.Max(math.Abs(left), math.Abs(right)); peak*m.limiterGain > limiterThreshold {
+			m.limiterGain = limiterThreshold / peak
+		}
+		out[frame*2] = float32(left * m.limiterGain)
+		out[frame*2+1] = float32(right * m.limiterGain)
+		level := math.Max(math.Abs(left), math.Abs(right)) * m.limiterGain
+		peak = math.Max(peak, level)
+		sum += level * level
+	}
+	// the meter jumps up to a new peak, and falls slowly
+	fall := math.Exp(-float64(len(out)/2) / (meterRelease * mixerSampleRate))
+	m.meterPeak = math.Max(peak, m.meterPeak*fall)
+	m.meterRMS = math.Max(math.Sqrt(sum/float64(max(len(out)/2, 1))), m.meterRMS*fall)
+	playing := m.voices[:0]
+	for _, v := range m.voices {
+		if v.pos < len(v.samples) {
+			playing = append(playing, v)
+		}
+	}
+	for i := len(playing); i < len(m.voices); i++ {
+		m.voices[i] = nil
+	}
+	m.voices = playing
+}
+
+// run feeds the output in real time, staying mixerLatency ahead of the audio device, until it is stopped or the output fails
+func (m *mixer) run(output AudioOutput, stop <-chan struct{}) error {
+	block := make([]float32, mixerBlockSize*2)
+	lead := int(mixerLatency.Seconds() * mixerSampleRate)
+	start := time.Now()
+	written := 0
+	lastRefresh := start
+	for {
+		due := int(time.Since(start).Seconds()*mixerSampleRate) + lead
+		for written < due {
+			m.mix(block)
+			if err := output.Write(block); err != nil {
+				return err
+			}
+			written += mixerBlockSize
+		}
+		// the window is only redrawn by itself when something happens, so it is redrawn while the meter moves
+		if peak, _ := m.levels(); peak > meterRange && time.Since(lastRefresh) > meterRefresh {
+			refreshWindow()
+			lastRefresh = time.Now()
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+var (
+	stopMixing    = make(chan struct{})
+	mixerFinished = make(chan struct{})
+)
+
+// startMixer opens the audio output with the given name, see openAudioOutput, and starts mixing in the background.
+// If the output can not be opened, the mixer runs without output, and the error is returned.
+func startMixer(name, recordPath string) error {
+	output, err := openAudioOutput(name, mixerSampleRate, recordPath)
+	switch output.(type) {
+	case nullOutput:
+		audioOutputName = "null"
+	case *wavOutput:
+		audioOutputName = "wav (" + recordPath + ")"
+	default:
+		audioOutputName = "playsample"
+	}
+	go func() {
+		defer close(mixerFinished)
+		if err := audioMixer.run(output, stopMixing); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Audio output stopped: %v", err))
+		}
+		output.Close()
+	}()
+	return err
+}
+
+// stopMixer stops the mixer and closes the audio output, which also finishes the file of the wav output
+func stopMixer() {
+	select {
+	case <-stopMixing:
+	default:
+		close(stopMixing)
+	}
+	<-mixerFinished
+}
+
+// panGains returns the gains of the left and right channel for a pan from -1 to 1. Both channels are left as they are
+// in the middle, and one channel is turned down towards the other side. The same gains are used for playing and
+// exporting, so that a pad sounds the same in the mixer as in its files, and a centered stereo pad is as loud as a mono pad.
+func panGains(pan float64) (float64, float64) {
+	pan = clamp(pan, -1, 1)
+	return math.Min(1, 1-pan), math.Min(1, 1+pan)
+}
+
+// anySolo returns true if any pad is soloed, in which case only the soloed pads are heard
+func anySolo() bool {
+	for _, opts := range padOpts {
+		if opts.Solo {
+			return true
+		}
+	}
+	return false
+}
+
+// audible returns false if a pad is muted, or if other pads are soloed
+func audible(padIndex int) bool {
+	opts := padOpts[padIndex]
+	return !opts.Mute && (opts.Solo || !anySolo())
+}
+
+// playSamples plays samples that are not from a pad, like the loaded WAV or a preview, in the middle
+func playSamples(samples []float64, sampleRate int) {
+	if sampleRate != mixerSampleRate {
+		samples = synth.Resample(samples, sampleRate, mixerSampleRate)
+	}
+	left, right := panGains(0)
+	audioMixer.trigger(&voice{padIndex: -1, samples: samples, left: left, right: right})
+}
+
+func createMixerWidget() g.Widget {
+	opts := padOpts[activePadIndex]
+	gain := float32(opts.Gain)
+	pan := float32(opts.Pan)
+	mute := opts.Mute
+	solo := opts.Solo
+	chokeGroups := []string{"None"}
+	for i := 1; i <= maxChokeGroups; i++ {
+		chokeGroups = append(chokeGroups, fmt.Sprint(i))
+	}
+	chokeGroup := int32(opts.ChokeGroup)
+	voices, reduction := audioMixer.status()
+	return g.Column(
+		g.Label(fmt.Sprintf("%s mixer:", padLabel(activePadIndex))),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Label("Gain (dB)"),
+			g.SliderFloat(&gain, minPadGain, maxPadGain).Size(150).OnChange(func() {
+				recordSliderEdit("Gain", activePadIndex)
+				padOpts[activePadIndex].Gain = float64(gain)
+			}),
+		),
+		g.Row(
+			g.Label("Pan"),
+			g.SliderFloat(&pan, -1, 1).Size(150).OnChange(func() {
+				recordSliderEdit("Pan", activePadIndex)
+				padOpts[activePadIndex].Pan = float64(pan)
+			}),
+		),
+		createStereoWidget(),
+		g.Row(
+			g.Checkbox("Mute", &mute).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Mute", padLabel(activePadIndex)), activePadIndex)
+				padOpts[activePadIndex].Mute = mute
+				if mute {
+					audioMixer.stopPad(activePadIndex)
+				}
+			}),
+			g.Checkbox("Solo", &solo).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Solo", padLabel(activePadIndex)), activePadIndex)
+				padOpts[activePadIndex].Solo = solo
+				for i := 0; i < numPads; i++ {
+					if !audible(i) {
+						audioMixer.stopPad(i)
+					}
+				}
+			}),
+		),
+		g.Row(
+			g.Label("Choke group"),
+			g.Combo("##chokeGroup", chokeGroups[chokeGroup], chokeGroups, &chokeGroup).Size(100).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Choke group", padLabel(activePadIndex)), activePadIndex)
+				padOpts[activePadIndex].ChokeGroup = int(chokeGroup)
+			}),
+		),
+		g.Dummy(30, 0),
+		g.Label(fmt.Sprintf("Output: %s", audioOutputName)),
+		g.Label(fmt.Sprintf("Voices: %d of %d", voices, maxVoices)),
+		g.Label(fmt.Sprintf("Limiter: %.1f dB", reduction)),
+		createLoudnessWidget(),
+		g.Button("Stop all sounds").OnClick(func() {
+			audioMixer.stopPad(-1)
+		}),
+	)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mixInto mixes the given number of frames into the output, a block at a time
+func mixInto(t *testing.T, m *mixer, output AudioOutput, frames int) {
+	t.Helper()
+	block := make([]float32, mixerBlockSize*2)
+	for written := 0; written < frames; written += mixerBlockSize {
+		m.mix(block)
+		if err := output.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMixerRecording(t *testing.T) {
+	m := &mixer{limiterGain: 1}
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = 0.5
+	}
+	m.trigger(&voice{padIndex: -1, samples: samples, left: 1, right: 0.5})
+
+	filePath := filepath.Join(t.TempDir(), "recording.wav")
+	output, err := newWavOutput(filePath, mixerSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixInto(t, m, output, 4*mixerBlockSize)
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if voices, _ := m.status(); voices != 0 {
+		t.Fatalf("%d voices are still playing", voices)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := decodeAudio(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audio.SampleRate != mixerSampleRate || audio.Channels != 2 || len(audio.Samples) != 4*mixerBlockSize*2 {
+		t.Fatalf("got %d Hz, %d channels and %d samples", audio.SampleRate, audio.Channels, len(audio.Samples))
+	}
+	for frame := 0; frame < len(audio.Samples)/2; frame++ {
+		left, right := 0.5, 0.25
+		if frame >= len(samples) {
+			left, right = 0, 0
+		}
+		if math.Abs(audio.Samples[frame*2]-left) > 0.001 || math.Abs(audio.Samples[frame*2+1]-right) > 0.001 {
+			t.Fatalf("frame %d is %f, %f and not %f, %f", frame, audio.Samples[frame*2], audio.Samples[frame*2+1], left, right)
+		}
+	}
+}
+
+func TestMixerStealing(t *testing.T) {
+	m := &mixer{limiterGain: 1}
+	var voices []*voice
+	for i := 0; i <= maxVoices; i++ {
+		v := &voice{padIndex: i, samples: make([]float64, 10*mixerBlockSize), left: 0.01, right: 0.01}
+		voices = append(voices, v)
+		m.trigger(v)
+	}
+	// the oldest voice fades out instead of stopping right away
+	if voices[0].fade != chokeFadeFrames {
+		t.Fatalf("the stolen voice has %d frames of fade left", voices[0].fade)
+	}
+	for _, v := range voices[1:] {
+		if v.fade != 0 {
+			t.Fatalf("voice %d is fading out", v.padIndex)
+		}
+	}
+	mixInto(t, m, nullOutput{}, chokeFadeFrames+mixerBlockSize)
+	if playing, _ := m.status(); playing != maxVoices {
+		t.Fatalf("%d voices are playing, not %d", playing, maxVoices)
+	}
+}
+
+func TestWavOutputFull(t *testing.T) {
+	output, err := newWavOutput(filepath.Join(t.TempDir(), "recording.wav"), mixerSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	output.frames = maxWavDataSize/4 - 1
+	if err := output.Write(make([]float32, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write(make([]float32, 2)); err == nil {
+		t.Fatal("the recording grew past 4 GiB")
+	}
+}
+
+func TestMixerChokeGroup(t *testing.T) {
+	m := &mixer{limiterGain: 1}
+	first := &voice{padIndex: -1, chokeGroup: morphChokeGroup, samples: make([]float64, 1000)}
+	other := &voice{padIndex: 0, chokeGroup: 1, samples: make([]float64, 1000)}
+	m.trigger(first)
+	m.trigger(other)
+	m.trigger(&voice{padIndex: -1, chokeGroup: morphChokeGroup, samples: make([]float64, 1000)})
+	if first.fade != chokeFadeFrames {
+		t.Fatal("a new preview of a morph did not choke the previous one")
+	}
+	if other.fade != 0 {
+		t.Fatal("a preview of a morph choked a pad")
+	}
+}
+
+func TestMixerFallback(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "missing", "recording.wav")
+	if err := startMixer("wav", recordPath); err == nil {
+		t.Fatal("no error for a recording that can not be created")
+	}
+	defer stopMixer()
+	if audioOutputName != "null" {
+		t.Fatalf("the mixer plays on %s, not on no output", audioOutputName)
+	}
+	for _, name := range []string{"wav", "nonexistent"} {
+		output, err := openAudioOutput(name, mixerSampleRate, recordPath)
+		if err == nil {
+			t.Fatalf("%s: no error", name)
+		}
+		if _, ok := output.(nullOutput); !ok {
+			t.Fatalf("%s: got %T, not no output", name, output)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+var (
+	morphFrom   int32
+	morphTo     int32 = 1
+	morphAmount float32
+	morphRow    int32
+)
+
+// morphChokeGroup is a choke group that no pad can be in, so that every preview of a morph stops the previous one
+const morphChokeGroup = maxChokeGroups + 1
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// lerpSlice interpolates the values that a and b have in common, and keeps the length of the nearest slice
+func lerpSlice(a, b []float64, t float64) []float64 {
+	nearest := a
+	if t >= 0.5 {
+		nearest = b
+	}
+	result := append([]float64(nil), nearest...)
+	for i := range result {
+		if i < len(a) && i < len(b) {
+			result[i] = lerp(a[i], b[i], t)
+		}
+	}
+	return result
+}
+
+// morphSettings returns a sound that is the given amount (0 to 1) on the way from a to b.
+// Continuous parameters are interpolated linearly. Choices that can not be blended, like the sound type,
+// the waveform and the number of oscillators, are taken from the nearest of the two.
+func morphSettings(a, b *synth.Settings, amount float64) *synth.Settings {
+	sa, sb := storeSettings(a), storeSettings(b)
+	m := sa
+	if amount >= 0.5 {
+		m = sb
+	}
+	m.StartFreq = lerp(sa.StartFreq, sb.StartFreq, amount)
+	m.EndFreq = lerp(sa.EndFreq, sb.EndFreq, amount)
+	m.Duration = lerp(sa.Duration, sb.Duration, amount)
+	m.NoiseAmount = lerp(sa.NoiseAmount, sb.NoiseAmount, amount)
+	m.Attack = lerp(sa.Attack, sb.Attack, amount)
+	m.Decay = lerp(sa.Decay, sb.Decay, amount)
+	m.Sustain = lerp(sa.Sustain, sb.Sustain, amount)
+	m.Release = lerp(sa.Release, sb.Release, amount)
+	m.Drive = lerp(sa.Drive, sb.Drive, amount)
+	m.FilterCutoff = lerp(sa.FilterCutoff, sb.FilterCutoff, amount)
+	m.FilterResonance = lerp(sa.FilterResonance, sb.FilterResonance, amount)
+	m.Sweep = lerp(sa.Sweep, sb.Sweep, amount)
+	m.PitchDecay = lerp(sa.PitchDecay, sb.PitchDecay, amount)
+	m.OscillatorLevels = lerpSlice(sa.OscillatorLevels, sb.OscillatorLevels, amount)
+	m.SaturatorAmount = lerp(sa.SaturatorAmount, sb.SaturatorAmount, amount)
+	m.FilterBands = lerpSlice(sa.FilterBands, sb.FilterBands, amount)
+	m.FadeDuration = lerp(sa.FadeDuration, sb.FadeDuration, amount)
+	m.ReverbAmount = lerp(sa.ReverbAmount, sb.ReverbAmount, amount)
+	m.ReverbDecay = lerp(sa.ReverbDecay, sb.ReverbDecay, amount)
+	m.DelayAmount = lerp(sa.DelayAmount, sb.DelayAmount, amount)
+	m.DelayTime = lerp(sa.DelayTime, sb.DelayTime, amount)
+	m.DelayFeedback = lerp(sa.DelayFeedback, sb.DelayFeedback, amount)
+	return m.settings()
+}
+
+// previewMorph plays the given sound in the background, and stops the previous preview
+func previewMorph(cfg *synth.Settings) {
+	go func() {
+		if err := GeneratePlay(cfg, padOptions{ChokeGroup: morphChokeGroup}); err != nil {
+			setStatusMessage(fmt.Sprintf("Error: Failed to play the morphed sound: %v", err))
+		}
+	}()
+}
+
+func currentMorph() *synth.Settings {
+	return morphSettings(pads[morphFrom], pads[morphTo], float64(morphAmount))
+}
+
+// fillMorphRow replaces a row of 4 pads with evenly spaced steps from one pad to the other
+func fillMorphRow(row int, from, to *synth.Settings) {
+	padIndices := []int{row * 4, row*4 + 1, row*4 + 2, row*4 + 3}
+	recordEdit(fmt.Sprintf("Morph into row %d", row+1), padIndices...)
+	for step, padIndex := range padIndices {
+		setLayerSettings(padIndex, 0, morphSettings(from, to, float64(step)/float64(len(padIndices)-1)))
+		roundRobinIndex[padIndex] = 0
+	}
+}
+
+func createMorphWidget() g.Widget {
+	var padLabels []string
+	for i := 0; i < numPads; i++ {
+		padLabels = append(padLabels, padLabel(i))
+	}
+	rows := []string{"Row 1", "Row 2", "Row 3", "Row 4"}
+	return g.Column(
+		g.Label("Morph between two pads:"),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Label("From"),
+			g.Combo("##morphFrom", padLabels[morphFrom], padLabels, &morphFrom).Size(100),
+			g.Label("To"),
+			g.Combo("##morphTo", padLabels[morphTo], padLabels, &morphTo).Size(100),
+		),
+		g.Row(
+			g.Label("Blend"),
+			g.SliderFloat(&morphAmount, 0.0, 1.0).Size(150).OnChange(func() {
+				previewMorph(currentMorph())
+			}),
+		),
+		g.Row(
+			g.Button("Play").OnClick(func() {
+				previewMorph(currentMorph())
+			}),
+			g.Button(fmt.Sprintf("Apply to %s", padLabel(activePadIndex))).OnClick(func() {
+				cfg := currentMorph()
+				recordEdit(fmt.Sprintf("%s: Morph", padLabel(activePadIndex)), activePadIndex)
+				setLayerSettings(activePadIndex, 0, cfg)
+				roundRobinIndex[activePadIndex] = 0
+			}),
+		),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Label("Fill"),
+			g.Combo("##morphRow", rows[morphRow], rows, &morphRow).Size(100),
+			g.Button("with 4 morph steps").OnClick(func() {
+				fillMorphRow(int(morphRow), synth.CopySettings(pads[morphFrom]), synth.CopySettings(pads[morphTo]))
+				setStatusMessage(fmt.Sprintf("Filled row %d with steps from %s to %s", morphRow+1, padLabel(int(morphFrom)), padLabel(int(morphTo))))
+			}),
+		),
+	)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	g "github.com/AllenDang/giu"
+)
+
+const (
+	oscPopup             = "OSC server"
+	defaultOSCAddress    = ":9000"
+	maxOSCPacketSize     = 65536
+	oscBundleIdentifier  = "#bundle"
+	oscBundleHeaderBytes = 16 // "#bundle\0" and a 64-bit time tag
+)
+
+// oscMessage is an OSC message, with int32, float32, float64, int64, string or bool arguments
+type oscMessage struct {
+	Address string
+	Args    []any
+}
+
+var (
+	oscAddress     = defaultOSCAddress
+	oscSendAddress string
+	oscStatus      = "Not running"
+	oscConn        *net.UDPConn
+	oscSendTo      *net.UDPAddr
+	oscMu          sync.Mutex
+)
+
+// readOSCString reads a null terminated string that is padded to a multiple of 4 bytes
+func readOSCString(data []byte) (string, []byte, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", nil, errors.New("unterminated OSC string")
+	}
+	size := (end + 4) &^ 3
+	if size > len(data) {
+		return "", nil, errors.New("OSC string is not padded")
+	}
+	return string(data[:end]), data[size:], nil
+}
+
+func writeOSCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.Write(make([]byte, 4-len(s)%4))
+}
+
+// parseOSC parses an OSC packet, which is either a message or a bundle of packets
+func parseOSC(data []byte) ([]oscMessage, error) {
+	if !bytes.HasPrefix(data, []byte(oscBundleIdentifier+"\x00")) {
+		msg, err := parseOSCMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		return []oscMessage{msg}, nil
+	}
+	if len(data) < oscBundleHeaderBytes {
+		return nil, errors.New("OSC bundle is too short")
+	}
+	var messages []oscMessage
+	for rest := data[oscBundleHeaderBytes:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return nil, errors.New("OSC bundle element is too short")
+		}
+		size := int(binary.BigEndian.Uint32(rest))
+		if size > len(rest)-4 || size%4 != 0 {
+			return nil, errors.New("invalid OSC bundle element size")
+		}
+		elementMessages, err := parseOSC(rest[4 : 4+size])
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, elementMessages...)
+		rest = rest[4+size:]
+	}
+	return messages, nil
+}
+
+func parseOSCMessage(data []byte) (oscMessage, error) {
+	var msg oscMessage
+	address, rest, err := readOSCString(data)
+	if err != nil {
+		return msg, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return msg, fmt.Errorf("invalid OSC address %q", address)
+	}
+	msg.Address = address
+	if len(rest) == 0 { // old implementations can leave out the type tags when there are no arguments
+		return msg, nil
+	}
+	typeTags, rest, err := readOSCString(rest)
+	if err != nil {
+		return msg, err
+	}
+	if !strings.HasPrefix(typeTags, ",") {
+		return msg, errors.New("missing OSC type tags")
+	}
+	for _, tag := range typeTags[1:] {
+		size := 0
+		switch tag {
+		case 'i', 'f':
+			size = 4
+		case 'h', 'd':
+			size = 8
+		}
+		if len(rest) < size {
+			return msg, errors.New("OSC message is too short for its arguments")
+		}
+		switch tag {
+		case 'i':
+			msg.Args = append(msg.Args, int32(binary.BigEndian.Uint32(rest)))
+		case 'f':
+			msg.Args = append(msg.Args, math.Float32frombits(binary.BigEndian.Uint32(rest)))
+		case 'h':
+			msg.Args = append(msg.Args, int64(binary.BigEndian.Uint64(rest)))
+		case 'd':
+			msg.Args = append(msg.Args, math.Float64frombits(binary.BigEndian.Uint64(rest)))
+		case 's':
+			var s string
+			if s, rest, err = readOSCString(rest); err != nil {
+				return msg, err
+			}
+			msg.Args = append(msg.Args, s)
+		case 'T':
+			msg.Args = append(msg.Args, true)
+		case 'F':
+			msg.Args = append(msg.Args, false)
+		case 'N', 'I':
+		default:
+			return msg, fmt.Errorf("unsupported OSC type tag %q", tag)
+		}
+		rest = rest[size:]
+	}
+	return msg, nil
+}
+
+// encodeOSC encodes an OSC message with int32, float32 and string arguments
+func encodeOSC(address string, args ...any) ([]byte, error) {
+	var buf, argBuf bytes.Buffer
+	typeTags := ","
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int32:
+			typeTags += "i"
+			binary.Write(&argBuf, binary.BigEndian, v)
+		case float32:
+			typeTags += "f"
+			binary.Write(&argBuf, binary.BigEndian, v)
+		case string:
+			typeTags += "s"
+			writeOSCString(&argBuf, v)
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument type %T", arg)
+		}
+	}
+	writeOSCString(&buf, address)
+	writeOSCString(&buf, typeTags)
+	buf.Write(argBuf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// oscFloat returns the given argument as a float64
+func oscFloat(msg oscMessage, i int) (float64, error) {
+	if i >= len(msg.Args) {
+		return 0, fmt.Errorf("%s needs a number", msg.Address)
+	}
+	switch v := msg.Args[i].(type) {
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%s needs a number", msg.Address)
+}
+
+func oscString(msg oscMessage, i int) (string, error) {
+	if i >= len(msg.Args) {
+		return "", fmt.Errorf("%s needs a string", msg.Address)
+	}
+	s, ok := msg.Args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("%s needs a string", msg.Address)
+	}
+	return s, nil
+}
+
+// parameterKey makes "Filter Cutoff", "filtercutoff" and "filter_cutoff" match
+func parameterKey(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// applyOSC applies one OSC message. It must only be called from the GUI loop, through runOnUI.
+func applyOSC(msg oscMessage) error {
+	parts := strings.Split(strings.Trim(msg.Address, "/"), "/")
+	training := atomic.LoadInt32(&trainingOngoing) == 1
+	switch {
+	case len(parts) == 3 && parts[0] == "pad":
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 || n > numPads {
+			return fmt.Errorf("%s: there is no pad %s", msg.Address, parts[1])
+		}
+		padIndex := n - 1
+		switch parts[2] {
+		case "trigger":
+			velocity := float64(playVelocity)
+			if len(msg.Args) > 0 {
+				if velocity, err = oscFloat(msg, 0); err != nil {
+					return err
+				}
+			}
+			play := playPad(padIndex, clamp(velocity, 0.01, 1))
+			go func() {
+				if err := play(); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to play sound: %v", err))
+				}
+			}()
+			return nil
+		case "select":
+			activePadIndex = padIndex
+			return nil
+		}
+		for _, p := range padParameters {
+			if parameterKey(p.name) != parameterKey(parts[2]) {
+				continue
+			}
+			if training && padIndex == trainingPadIndex {
+				return fmt.Errorf("%s: %s is being trained", msg.Address, padLabel(padIndex))
+			}
+			value, err := oscFloat(msg, 0)
+			if err != nil {
+				return err
+			}
+			recordSliderEdit(p.name, padIndex)
+			*p.field(pads[padIndex]) = clamp(value, p.min, p.max)
+			return nil
+		}
+	case msg.Address == "/kit/load" || msg.Address == "/kit/save":
+		filePath, err := oscString(msg, 0)
+		if err != nil {
+			return err
+		}
+		if msg.Address == "/kit/save" {
+			return saveKit(filePath)
+		}
+		if training {
+			return fmt.Errorf("%s: stop the training first", msg.Address)
+		}
+		return loadKit(filePath)
+	case msg.Address == "/train/start":
+		if training {
+			return fmt.Errorf("%s: the training is already ongoing", msg.Address)
+		}
+		if len(msg.Args) > 0 {
+			n, err := oscFloat(msg, 0)
+			if err != nil || n < 1 || n > numPads {
+				return fmt.Errorf("%s: there is no pad %v", msg.Address, msg.Args[0])
+			}
+			activePadIndex = int(n) - 1
+		}
+		if len(loadedWaveform) == 0 {
+			return fmt.Errorf("%s: no WAV file is loaded", msg.Address)
+		}
+		toggleTraining()
+		return nil
+	case msg.Address == "/train/stop":
+		if training {
+			toggleTraining()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown OSC address %s", msg.Address)
+}
+
+// serveOSC receives OSC packets until the connection is closed.
+// Errors are shown in the status line and sent back to the sender as an /error message.
+func serveOSC(conn *net.UDPConn) {
+	buf := make([]byte, maxOSCPacketSize)
+	for {
+		n, sender, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		messages, err := parseOSC(buf[:n])
+		if err != nil {
+			oscReply(conn, sender, err)
+			continue
+		}
+		for _, msg := range messages {
+			runOnUI(func() {
+				if err := applyOSC(msg); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: OSC: %v", err))
+					oscReply(conn, sender, err)
+				}
+			})
+		}
+	}
+}
+
+func oscReply(conn *net.UDPConn, addr *net.UDPAddr, replyErr error) {
+	if data, err := encodeOSC("/error", replyErr.Error()); err == nil {
+		conn.WriteToUDP(data, addr)
+	}
+}
+
+// oscBroadcast sends a message to the OSC send address, if the OSC server is running and a send address is set
+func oscBroadcast(address string, args ...any) {
+	oscMu.Lock()
+	defer oscMu.Unlock()
+	if oscConn == nil || oscSendTo == nil {
+		return
+	}
+	if data, err := encodeOSC(address, args...); err == nil {
+		oscConn.WriteToUDP(data, oscSendTo)
+	}
+}
+
+// startOSC listens for OSC messages on the given UDP address, and sends training progress to sendAddress, if it is set
+func startOSC(address, sendAddress string) error {
+	stopOSC()
+	var sendTo *net.UDPAddr
+	if sendAddress != "" {
+		var err error
+		if sendTo, err = net.ResolveUDPAddr("udp", sendAddress); err != nil {
+			return err
+		}
+	}
+	listenAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", listenAddr)
+	if err != nil {
+		return err
+	}
+	oscMu.Lock()
+	oscConn = conn
+	oscSendTo = sendTo
+	oscStatus = fmt.Sprintf("Listening on %s", conn.LocalAddr())
+	if sendTo != nil {
+		oscStatus += fmt.Sprintf(", sending progress to %s", sendTo)
+	}
+	oscMu.Unlock()
+	go serveOSC(conn)
+	return nil
+}
+
+func stopOSC() {
+	oscMu.Lock()
+	defer oscMu.Unlock()
+	if oscConn != nil {
+		oscConn.Close()
+		oscConn = nil
+	}
+	oscStatus = "Not running"
+}
+
+func oscPopupWidget() g.Widget {
+	oscMu.Lock()
+	status := oscStatus
+	oscMu.Unlock()
+	return g.PopupModal(oscPopup).Layout(
+		g.Row(
+			g.Label("Listen on"),
+			g.InputText(&oscAddress).Size(200),
+		),
+		g.Row(
+			g.Label("Send progress to"),
+			g.InputText(&oscSendAddress).Size(200).Hint("127.0.0.1:9001"),
+		),
+		g.Label(status),
+		g.Label("/pad/N/trigger, /pad/N/select, /pad/N/attack 0.2 (or decay, sustain, release, drive,"),
+		g.Label("filtercutoff, sweep, pitchdecay), /kit/load path, /kit/save path, /train/start, /train/stop"),
+		g.Row(
+			g.Button("Start").OnClick(func() {
+				if err := startOSC(oscAddress, oscSendAddress); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to start the OSC server: %v", err))
+				}
+			}),
+			g.Button("Stop").OnClick(stopOSC),
+			g.Button("Close").OnClick(func() {
+				g.CloseCurrentPopup()
+			}),
+		),
+	)
+}
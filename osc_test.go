@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func encodeOSCMessage(t *testing.T, address string, args ...any) []byte {
+	t.Helper()
+	data, err := encodeOSC(address, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// oscBundle returns a bundle with the given elements, each after its size
+func oscBundle(elements ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(oscBundleIdentifier + "\x00")
+	binary.Write(&buf, binary.BigEndian, uint64(1)) // immediately
+	for _, element := range elements {
+		binary.Write(&buf, binary.BigEndian, uint32(len(element)))
+		buf.Write(element)
+	}
+	return buf.Bytes()
+}
+
+func TestOSCRoundTrip(t *testing.T) {
+	for _, args := range [][]any{
+		nil,
+		{int32(3)},
+		{float32(0.25), "Drive"},
+		{"", "abc", "abcd", int32(-1)},
+	} {
+		messages, err := parseOSC(encodeOSCMessage(t, "/pad/play", args...))
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if len(messages) != 1 || messages[0].Address != "/pad/play" || !reflect.DeepEqual(messages[0].Args, args) {
+			t.Fatalf("%v: got %+v", args, messages)
+		}
+	}
+	bundle := oscBundle(encodeOSCMessage(t, "/a", int32(1)), oscBundle(encodeOSCMessage(t, "/b", "x")))
+	messages, err := parseOSC(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []oscMessage{{"/a", []any{int32(1)}}, {"/b", []any{"x"}}}
+	if !reflect.DeepEqual(messages, want) {
+		t.Fatalf("got %+v, not %+v", messages, want)
+	}
+}
+
+func TestOSCInvalid(t *testing.T) {
+	message := encodeOSCMessage(t, "/pad/gain", float32(1), "name")
+	// the type tags say there are two numbers, but there is only one
+	shortArgs := encodeOSCMessage(t, "/pad/gain", float32(1))
+	shortArgs = bytes.Replace(shortArgs, []byte(",f\x00\x00"), []byte(",ff\x00"), 1)
+	element := encodeOSCMessage(t, "/a")
+	unaligned := oscBundle(append(element, 0))
+	packets := map[string][]byte{
+		"no address":                    encodeOSCMessage(t, "pad"),
+		"unterminated address":          []byte("/pad"),
+		"unpadded address":              []byte("/pad/play\x00"),
+		"truncated string argument":     message[:len(message)-4],
+		"arguments shorter than tags":   shortArgs,
+		"unaligned bundle element":      unaligned,
+		"bundle element past the end":   oscBundle(element)[:oscBundleHeaderBytes+4+len(element)-4],
+		"bundle element without a size": append(oscBundle(), 0, 0),
+		"short bundle header":           []byte(oscBundleIdentifier + "\x00\x00"),
+		"unsupported type tag":          []byte("/a\x00\x00,x\x00\x00"),
+	}
+	for name, packet := range packets {
+		if messages, err := parseOSC(packet); err == nil {
+			t.Errorf("%s: got %+v", name, messages)
+		}
+	}
+	// every truncated message either parses or returns an error, without panicking
+	for length := range message {
+		parseOSC(message[:length])
+	}
+}
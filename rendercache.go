@@ -0,0 +1,158 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/xyproto/synth"
+)
+
+// maxRenderCacheSize is the most memory that rendered sounds and their analysis data may use, in bytes
+const maxRenderCacheSize = 256 << 20
+
+type settingsHash [sha256.Size]byte
+
+// renderEntry is a rendered sound, and the data that has been derived from it
+type renderEntry struct {
+	hash    settingsHash
+	samples []float64
+	// analysis is data that is derived from the samples, like resampled copies and spectra, by name
+	analysis map[string][]float64
+	size     int
+	// source keeps what a named entry was made from from being garbage collected, see cached
+	source any
+}
+
+// renderCache keeps the most recently used rendered sounds, so that playing, plotting, exporting and
+// training only generate a sound once for the same settings
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[settingsHash]*list.Element
+	order   *list.List // the most recently used entry is at the front
+	size    int
+	maxSize int
+}
+
+var renders = newRenderCache(maxRenderCacheSize)
+
+func newRenderCache(maxSize int) *renderCache {
+	return &renderCache{entries: make(map[settingsHash]*list.Element), order: list.New(), maxSize: maxSize}
+}
+
+// hashSettings returns a hash of everything that affects the sound of the settings. The fields of storedSettings
+// always have the same order, so the JSON is canonical. Settings that can not be marshalled, like NaN values, are not hashed.
+func hashSettings(cfg *synth.Settings) (settingsHash, bool) {
+	data, err := json.Marshal(storeSettings(cfg))
+	if err != nil {
+		return settingsHash{}, false
+	}
+	return sha256.Sum256(data), true
+}
+
+func (c *renderCache) get(hash settingsHash) *renderEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*renderEntry)
+	}
+	return nil
+}
+
+// grow adds to the size of an entry, and removes the least recently used entries until the cache fits again
+func (c *renderCache) grow(entry *renderEntry, size int) {
+	entry.size += size
+	c.size += size
+	for c.size > c.maxSize && c.order.Len() > 1 {
+		oldest := c.order.Back()
+		evicted := oldest.Value.(*renderEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, evicted.hash)
+		c.size -= evicted.size
+	}
+}
+
+// render returns the sound of the settings, and only generates it if it is not in the cache.
+// The returned samples are shared, and must not be modified.
+func (c *renderCache) render(cfg *synth.Settings) ([]float64, error) {
+	hash, ok := hashSettings(cfg)
+	if !ok {
+		return cfg.Generate()
+	}
+	if entry := c.get(hash); entry != nil {
+		return entry.samples, nil
+	}
+	samples, err := cfg.Generate()
+	if err != nil {
+		return nil, err
+	}
+	return c.add(hash, samples, nil), nil
+}
+
+// add puts rendered samples in the cache, and returns them, or the samples that were rendered by someone else in the meantime
+func (c *renderCache) add(hash settingsHash, samples []float64, source any) []float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[hash]; ok {
+		return element.Value.(*renderEntry).samples
+	}
+	entry := &renderEntry{hash: hash, samples: samples, analysis: make(map[string][]float64), source: source}
+	c.entries[hash] = c.order.PushFront(entry)
+	c.grow(entry, 8*len(samples))
+	return samples
+}
+
+// cached returns a sound that is not rendered from settings alone, like the sound of a sample pad, and only computes it
+// if it is not in the cache. The name must identify the sound. If the name refers to data by its address, that data
+// must be given as the source, so that the address is not reused while the entry is cached. The returned samples are
+// shared, and must not be modified.
+func (c *renderCache) cached(name string, source any, compute func() []float64) []float64 {
+	hash := settingsHash(sha256.Sum256([]byte(name)))
+	if entry := c.get(hash); entry != nil {
+		return entry.samples
+	}
+	return c.add(hash, compute(), source)
+}
+
+// analyze returns data that is derived from the sound of the settings, and only computes it if it is not in the cache.
+// The name must identify what compute does. The returned data is shared, and must not be modified.
+func (c *renderCache) analyze(cfg *synth.Settings, name string, compute func(samples []float64) []float64) ([]float64, error) {
+	samples, err := c.render(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := hashSettings(cfg)
+	entry := c.get(hash)
+	if !ok || entry == nil {
+		return compute(samples), nil
+	}
+	c.mu.Lock()
+	data, ok := entry.analysis[name]
+	c.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	data = compute(samples)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := entry.analysis[name]; !ok {
+		entry.analysis[name] = data
+		if _, cached := c.entries[hash]; cached {
+			c.grow(entry, 8*len(data))
+		}
+	}
+	return data, nil
+}
+
+// resampled returns the sound of the settings at the given sample rate
+func (c *renderCache) resampled(cfg *synth.Settings, sampleRate int) ([]float64, error) {
+	if cfg.SampleRate == sampleRate {
+		return c.render(cfg)
+	}
+	return c.analyze(cfg, fmt.Sprintf("resampled %d", sampleRate), func(samples []float64) []float64 {
+		return synth.Resample(samples, cfg.SampleRate, sampleRate)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/AllenDang/cimgui-go/imgui"
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	// sampleDragDropType is the ImGui payload type used when dragging the loaded WAV onto a pad
+	sampleDragDropType = "KICKPAD_SAMPLE"
+	// sampleBitDepth is the bit depth that samples are stored with in kit files and WAV metadata
+	sampleBitDepth = 24
+	maxSamplePitch = 24 // semitones
+)
+
+// padSample is a recording that a pad plays instead of a synthesized sound
+type padSample struct {
+	Name string
+	// Samples are mono, and never modified, so that copies of a pad can share them
+	Samples    []float64 `json:"-"`
+	SampleRate int       `json:"-"`
+	Gain       float64   // dB
+	Pitch      float64   // semitones
+	Start      float64   // ms, how much is cut from the start of the recording
+	Length     float64   // ms, 0 for the rest of the recording
+	FadeOut    float64   // ms
+}
+
+// sampleFields has the fields of padSample without its methods, so that they can be marshalled as they are
+type sampleFields padSample
+
+// storedSample is how a padSample is stored in kit files and WAV metadata, with the recording as a WAV file
+type storedSample struct {
+	sampleFields
+	WAV []byte
+}
+
+// loadedWaveformName is the name of the loaded WAV, which is used as the name of a sample that is made from it
+var loadedWaveformName string
+
+func (s padSample) MarshalJSON() ([]byte, error) {
+	wav, err := encodeWav(s.Samples, s.SampleRate, sampleBitDepth, 1)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(storedSample{sampleFields: sampleFields(s), WAV: wav})
+}
+
+func (s *padSample) UnmarshalJSON(data []byte) error {
+	var stored storedSample
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	audio, err := decodeAudio(stored.WAV)
+	if err != nil {
+		return fmt.Errorf("invalid sample %s: %v", stored.Name, err)
+	}
+	if audio.SampleRate <= 0 || audio.Channels != 1 {
+		return errors.New("invalid sample " + stored.Name)
+	}
+	*s = padSample(stored.sampleFields)
+	s.Samples, s.SampleRate = audio.Samples, audio.SampleRate
+	return nil
+}
+
+// render returns the trimmed, pitched and faded recording at the given sample rate
+func (s *padSample) render(sampleRate int) []float64 {
+	start := int(clamp(s.Start/1000*float64(s.SampleRate), 0, float64(len(s.Samples))))
+	end := len(s.Samples)
+	if s.Length > 0 {
+		end = start + int(clamp(s.Length/1000*float64(s.SampleRate), 0, float64(end-start)))
+	}
+	// a higher pitch is the same as playing the recording as if it had a higher sample rate
+	sourceRate := int(math.Round(float64(s.SampleRate) * math.Pow(2, clamp(s.Pitch, -maxSamplePitch, maxSamplePitch)/12)))
+	samples := synth.Resample(s.Samples[start:end], sourceRate, sampleRate)
+	out := make([]float64, len(samples))
+	gain := math.Pow(10, s.Gain/20)
+	fade := int(s.FadeOut / 1000 * float64(sampleRate))
+	for i, sample := range samples {
+		out[i] = sample * gain
+		if remaining := len(samples) - i; remaining < fade {
+			out[i] *= float64(remaining) / float64(fade)
+		}
+	}
+	return out
+}
+
+// key identifies the sound of the sample. The recording is identified by its address, since it is never modified.
+func (s *padSample) key() string {
+	return fmt.Sprintf("%p %d %d gain %g pitch %g start %g length %g fade %g", s.Samples, len(s.Samples), s.SampleRate, s.Gain, s.Pitch, s.Start, s.Length, s.FadeOut)
+}
+
+// duration returns the length of the whole recording, in ms
+func (s *padSample) duration() float64 {
+	return float64(len(s.Samples)) / float64(s.SampleRate) * 1000
+}
+
+// setPadSample makes a pad play the loaded WAV, or a synthesized sound again for nil
+func setPadSample(padIndex int, samples []float64, name string) {
+	if samples == nil {
+		recordEdit(fmt.Sprintf("%s: Remove sample", padLabel(padIndex)), padIndex)
+		padOpts[padIndex].Sample = nil
+		setStatusMessage(fmt.Sprintf("%s plays a synthesized sound again", padLabel(padIndex)))
+		return
+	}
+	recordEdit(fmt.Sprintf("%s: Sample %s", padLabel(padIndex), name), padIndex)
+	padOpts[padIndex].Sample = &padSample{Name: name, Samples: samples, SampleRate: targetSampleRate}
+	setStatusMessage(fmt.Sprintf("%s plays %s", padLabel(padIndex), name))
+}
+
+// sampleDragSource lets the previous widget be dragged onto a pad, to make that pad play the loaded WAV
+func sampleDragSource() g.Widget {
+	return g.Custom(func() {
+		if imgui.BeginDragDropSource() {
+			imgui.SetDragDropPayload(sampleDragDropType, 0, 0)
+			g.Label(fmt.Sprintf("Put %s on...", loadedWaveformName)).Build()
+			imgui.EndDragDropSource()
+		}
+	})
+}
+
+// createSampleWidget shows how the active pad plays its sample
+func createSampleWidget() g.Widget {
+	s := padOpts[activePadIndex].Sample
+	return g.Column(
+		g.Label(fmt.Sprintf("Sample: %s (%.0f ms)", s.Name, s.duration())),
+		effectSlider("Sample gain (dB)", &s.Gain, minPadGain, maxPadGain),
+		effectSlider("Pitch (semitones)", &s.Pitch, -maxSamplePitch, maxSamplePitch),
+		effectSlider("Start (ms)", &s.Start, 0, float32(s.duration())),
+		effectSlider("Length (ms, 0 is all)", &s.Length, 0, float32(s.duration())),
+		effectSlider("Fade out (ms)", &s.FadeOut, 0, 500),
+		g.Button("Remove sample").OnClick(func() {
+			setPadSample(activePadIndex, nil, "")
+		}),
+	)
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xyproto/synth"
+)
+
+const (
+	defaultServeAddress = "localhost:8080"
+	maxUploadSize       = 64 << 20
+	// maxDoneJobs is how many finished or canceled jobs are kept, so that their results can still be fetched
+	maxDoneJobs = 32
+	// maxRunningJobs is how many optimizer jobs can run at the same time
+	maxRunningJobs = 4
+
+	jobRunning  = "running"
+	jobFinished = "finished"
+	jobCanceled = "canceled"
+)
+
+// apiPad is a pad, as it is listed and updated through the HTTP API
+type apiPad struct {
+	Pad      int
+	Label    string
+	Type     string
+	Color    string
+	Options  padOptions
+	Settings storedSettings
+}
+
+// apiParameter is a parameter that has a slider, with the name of its field in the settings
+type apiParameter struct {
+	Name     string
+	Field    string
+	Min, Max float64
+}
+
+// optimizerJob is an optimizer run that was started through the HTTP API.
+// Every job has its own target, output format and cancel channel, so that jobs can run at the same time
+// without sharing state with each other, or with the pads.
+type optimizerJob struct {
+	mu         sync.Mutex
+	id         string
+	state      string
+	generation int
+	fitness    float64
+	best       *synth.Settings
+	result     string
+	cancel     chan struct{}
+}
+
+// jobStatus is an optimizer job, as it is returned by the HTTP API
+type jobStatus struct {
+	ID         string
+	State      string
+	Generation int
+	Fitness    float64
+	Result     string
+	Settings   *storedSettings
+}
+
+func (job *optimizerJob) status() jobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := jobStatus{
+		ID:         job.id,
+		State:      job.state,
+		Generation: job.generation,
+		Fitness:    job.fitness,
+		Result:     job.result,
+	}
+	if job.best != nil {
+		stored := storeSettings(job.best)
+		status.Settings = &stored
+	}
+	if math.IsInf(status.Fitness, 0) {
+		status.Fitness = -1 // JSON can not represent infinity
+	}
+	return status
+}
+
+type apiServer struct {
+	mu     sync.Mutex
+	jobs   map[string]*optimizerJob
+	nextID int
+	// running is the number of optimizer goroutines, including canceled jobs that have not stopped yet
+	running int
+}
+
+// serve runs the HTTP API, for "kickpad serve --addr localhost:8080"
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", defaultServeAddress, "the address to serve the HTTP API on")
+	flags.Parse(args)
+	initPads()
+	// there is no GUI loop in this mode, so the changes to the pads are applied here instead
+	go func() {
+		for f := range uiQueue {
+			f()
+		}
+	}()
+	server := &apiServer{jobs: make(map[string]*optimizerJob)}
+	log.Printf("%s is serving the browser UI and the HTTP API on http://%s/", versionString, *addr)
+	return http.ListenAndServe(*addr, server.handler())
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pads", s.listPads)
+	mux.HandleFunc("GET /pads/{pad}", s.getPad)
+	mux.HandleFunc("PUT /pads/{pad}", s.updatePad)
+	mux.HandleFunc("GET /pads/{pad}/wav", s.renderPad)
+	mux.HandleFunc("PUT /pads/{pad}/{parameter}", s.setParameter)
+	mux.HandleFunc("POST /pads/{pad}/randomize", s.randomizePad)
+	mux.HandleFunc("GET /parameters", s.listParameters)
+	mux.HandleFunc("POST /render", s.renderSettings)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("POST /jobs", s.startJob)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("DELETE /jobs/{id}", s.cancelJob)
+	mux.HandleFunc("POST /jobs/{id}/apply", s.applyJob)
+	mux.Handle("GET /", webHandler())
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeWavResponse(w http.ResponseWriter, samples []float64, cfg *synth.Settings) {
+	data, err := encodeWav(samples, cfg.SampleRate, cfg.BitDepth, cfg.Channels)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// padIndexParameter returns the pad index for a pad number in the URL, which starts at 1
+func padIndexParameter(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > numPads {
+		return 0, fmt.Errorf("there is no pad %s", value)
+	}
+	return n - 1, nil
+}
+
+func validSettings(s storedSettings) error {
+	if s.SampleRate <= 0 || s.BitDepth <= 0 || s.Channels <= 0 || s.Duration <= 0 {
+		return errors.New("SampleRate, BitDepth, Channels and Duration must be larger than 0")
+	}
+	return nil
+}
+
+func currentPad(padIndex int) apiPad {
+	c := pads[padIndex].Color()
+	return apiPad{
+		Pad:      padIndex + 1,
+		Label:    padLabel(padIndex),
+		Type:     pads[padIndex].SoundType.String(),
+		Color:    fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+		Options:  padOpts[padIndex],
+		Settings: storeSettings(pads[padIndex]),
+	}
+}
+
+func (s *apiServer) listPads(w http.ResponseWriter, r *http.Request) {
+	var list []apiPad
+	callOnUI(func() {
+		for i := 0; i < numPads; i++ {
+			list = append(list, currentPad(i))
+		}
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *apiServer) getPad(w http.ResponseWriter, r *http.Request) {
+	padIndex, err := padIndexParameter(r.PathValue("pad"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var pad apiPad
+	callOnUI(func() { pad = currentPad(padIndex) })
+	writeJSON(w, http.StatusOK, pad)
+}
+
+// updatePad replaces the settings of a pad. The options are only replaced if they are given.
+func (s *apiServer) updatePad(w http.ResponseWriter, r *http.Request) {
+	padIndex, err := padIndexParameter(r.PathValue("pad"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var pad kitPad
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadSize)).Decode(&pad); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validSettings(pad.Settings); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var updated apiPad
+	callOnUI(func() {
+		recordEdit(fmt.Sprintf("%s: Update from the HTTP API", padLabel(padIndex)), padIndex)
+		restoreMetadata(padIndex, &wavMetadata{Version: versionString, Options: pad.Options, Settings: pad.Settings})
+		updated = currentPad(padIndex)
+	})
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *apiServer) listParameters(w http.ResponseWriter, r *http.Request) {
+	list := make([]apiParameter, len(padParameters))
+	for i, p := range padParameters {
+		list[i] = apiParameter{Name: p.name, Field: strings.ReplaceAll(p.name, " ", ""), Min: p.min, Max: p.max}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// setParameter sets one parameter of a pad to the number in the body. Changes to the same parameter
+// in quick succession are one change in the history, like when a slider is dragged.
+func (s *apiServer) setParameter(w http.ResponseWriter, r *http.Request) {
+	padIndex, err := padIndexParameter(r.PathValue("pad"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var p *padParameter
+	for i := range padParameters {
+		if parameterKey(padParameters[i].name) == parameterKey(r.PathValue("parameter")) {
+			p = &padParameters[i]
+		}
+	}
+	if p == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("there is no parameter %s", r.PathValue("parameter")))
+		return
+	}
+	var value float64
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadSize)).Decode(&value); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if value < p.min || value > p.max {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be from %g to %g", p.name, p.min, p.max))
+		return
+	}
+	var updated apiPad
+	callOnUI(func() {
+		recordSliderEdit(p.name, padIndex)
+		*p.field(pads[padIndex]) = value
+		updated = currentPad(padIndex)
+	})
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *apiServer) randomizePad(w http.ResponseWriter, r *http.Request) {
+	padIndex, err := padIndexParameter(r.PathValue("pad"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var updated apiPad
+	callOnUI(func() {
+		randomizePad(padIndex)
+		updated = currentPad(padIndex)
+	})
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// renderPad returns a pad as a WAV file, the same way as it is played, with an optional velocity from 0 to 1
+func (s *apiServer) renderPad(w http.ResponseWriter, r *http.Request) {
+	padIndex, err := padIndexParameter(r.PathValue("pad"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	velocity := 1.0
+	if v := r.URL.Query().Get("velocity"); v != "" {
+		if velocity, err = strconv.ParseFloat(v, 64); err != nil || velocity <= 0 || velocity > 1 {
+			writeError(w, http.StatusBadRequest, errors.New("velocity must be larger than 0 and at most 1"))
+			return
+		}
+	}
+	var (
+		samples []float64
+		cfg     *synth.Settings
+	)
+	callOnUI(func() { samples, cfg, err = renderPad(padIndex, velocity) })
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeWavResponse(w, samples, cfg)
+}
+
+// renderSettings returns the sound of the posted settings as a WAV file, without changing any pads
+func (s *apiServer) renderSettings(w http.ResponseWriter, r *http.Request) {
+	var stored storedSettings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadSize)).Decode(&stored); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validSettings(stored); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cfg := stored.settings()
+	// the settings are rendered without the options of a pad, so stereo settings are centered and not widened
+	samples, err := padOptions{}.renderChannels(cfg, padSound{}, cfg.SampleRate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeWavResponse(w, samples, cfg)
+}
+
+// queryInt returns an integer from the query string, or the default value if it is not given
+func queryInt(r *http.Request, name string, defaultValue int, allowed ...int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	for _, a := range allowed {
+		if n == a {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%s must be one of %v", name, allowed)
+}
+
+// startJob starts an optimizer job with the posted WAV, FLAC or AIFF file as the target
+func (s *apiServer) startJob(w http.ResponseWriter, r *http.Request) {
+	rate, err := queryInt(r, "rate", targetSampleRate, sampleRates...)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	bits, err := queryInt(r, "bits", defaultBitDepth, 16, 24)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	allWaveforms := r.URL.Query().Get("allWaveforms") != "false"
+	loudnessInvariant := r.URL.Query().Get("loudnessInvariant") == "true"
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	audio, err := decodeAudio(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	target := targetWaveform(audio)
+	if len(target) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("the target has no samples"))
+		return
+	}
+	if rate != targetSampleRate {
+		target = synth.Resample(target, targetSampleRate, rate)
+	}
+
+	s.mu.Lock()
+	if s.running >= maxRunningJobs {
+		s.mu.Unlock()
+		writeError(w, http.StatusTooManyRequests, fmt.Errorf("%d jobs are already running, wait for one to finish or cancel one", maxRunningJobs))
+		return
+	}
+	s.running++
+	s.nextID++
+	job := &optimizerJob{
+		id:      strconv.Itoa(s.nextID),
+		state:   jobRunning,
+		fitness: math.Inf(1),
+		cancel:  make(chan struct{}),
+	}
+	s.jobs[job.id] = job
+	s.pruneJobs()
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			s.running--
+			s.mu.Unlock()
+		}()
+		best, fitness, result := runOptimizer(target, rate, bits, allWaveforms, loudnessInvariant, padSound{}, job.cancel, func(generation int, best *synth.Settings, fitness float64, improved bool) {
+			job.mu.Lock()
+			defer job.mu.Unlock()
+			job.generation = generation
+			job.fitness = fitness
+			job.best = best
+		})
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.best = best
+		job.fitness = fitness
+		job.result = result
+		if job.state == jobRunning {
+			job.state = jobFinished
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, job.status())
+}
+
+// pruneJobs forgets the oldest jobs that are no longer running, when there are more than maxDoneJobs of them.
+// s.mu must be held.
+func (s *apiServer) pruneJobs() {
+	var done []int
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		running := job.state == jobRunning
+		job.mu.Unlock()
+		if !running {
+			n, _ := strconv.Atoi(id)
+			done = append(done, n)
+		}
+	}
+	if len(done) <= maxDoneJobs {
+		return
+	}
+	sort.Ints(done)
+	for _, n := range done[:len(done)-maxDoneJobs] {
+		delete(s.jobs, strconv.Itoa(n))
+	}
+}
+
+func (s *apiServer) findJob(w http.ResponseWriter, r *http.Request) *optimizerJob {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("there is no job %s", r.PathValue("id")))
+		return nil
+	}
+	return job
+}
+
+func (s *apiServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*optimizerJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+	list := make([]jobStatus, len(jobs))
+	for i, job := range jobs {
+		list[i] = job.status()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(list[i].ID)
+		b, _ := strconv.Atoi(list[j].ID)
+		return a < b
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *apiServer) getJob(w http.ResponseWriter, r *http.Request) {
+	if job := s.findJob(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.status())
+	}
+}
+
+func (s *apiServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job := s.findJob(w, r)
+	if job == nil {
+		return
+	}
+	job.mu.Lock()
+	if job.state == jobRunning {
+		job.state = jobCanceled
+		close(job.cancel)
+	}
+	job.mu.Unlock()
+	writeJSON(w, http.StatusOK, job.status())
+}
+
+// applyJob copies the best settings that a job has found so far into the given pad
+func (s *apiServer) applyJob(w http.ResponseWriter, r *http.Request) {
+	job := s.findJob(w, r)
+	if job == nil {
+		return
+	}
+	padIndex, err := padIndexParameter(r.URL.Query().Get("pad"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job.mu.Lock()
+	var best *synth.Settings
+	if job.best != nil {
+		best = synth.CopySettings(job.best)
+	}
+	job.mu.Unlock()
+	if best == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has no result yet", job.id))
+		return
+	}
+	var updated apiPad
+	callOnUI(func() {
+		recordEdit(fmt.Sprintf("%s: Result of job %s", padLabel(padIndex), job.id), padIndex)
+		setLayerSettings(padIndex, 0, best)
+		roundRobinIndex[padIndex] = 0
+		updated = currentPad(padIndex)
+	})
+	writeJSON(w, http.StatusOK, updated)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/xyproto/synth"
+)
+
+// storedSettings holds the fields of synth.Settings that describe a sound.
+// synth.Settings can not be marshalled as it is, since it also has an io.WriteSeeker and fade curve functions.
+type storedSettings struct {
+	SoundType                  synth.SoundType
+	SampleRate                 int
+	BitDepth                   int
+	Channels                   int
+	StartFreq                  float64
+	EndFreq                    float64
+	Duration                   float64
+	WaveformType               int
+	NoiseAmount                float64
+	Attack                     float64
+	Decay                      float64
+	Sustain                    float64
+	Release                    float64
+	Drive                      float64
+	FilterCutoff               float64
+	FilterResonance            float64
+	Sweep                      float64
+	PitchDecay                 float64
+	NumOscillators             int
+	OscillatorLevels           []float64
+	SaturatorAmount            float64
+	FilterBands                []float64
+	FadeDuration               float64
+	SmoothFrequencyTransitions bool
+	ReverbAmount               float64
+	ReverbDecay                float64
+	DelayAmount                float64
+	DelayTime                  float64
+	DelayFeedback              float64
+}
+
+func storeSettings(cfg *synth.Settings) storedSettings {
+	return storedSettings{
+		SoundType:                  cfg.SoundType,
+		SampleRate:                 cfg.SampleRate,
+		BitDepth:                   cfg.BitDepth,
+		Channels:                   cfg.Channels,
+		StartFreq:                  cfg.StartFreq,
+		EndFreq:                    cfg.EndFreq,
+		Duration:                   cfg.Duration,
+		WaveformType:               cfg.WaveformType,
+		NoiseAmount:                cfg.NoiseAmount,
+		Attack:                     cfg.Attack,
+		Decay:                      cfg.Decay,
+		Sustain:                    cfg.Sustain,
+		Release:                    cfg.Release,
+		Drive:                      cfg.Drive,
+		FilterCutoff:               cfg.FilterCutoff,
+		FilterResonance:            cfg.FilterResonance,
+		Sweep:                      cfg.Sweep,
+		PitchDecay:                 cfg.PitchDecay,
+		NumOscillators:             cfg.NumOscillators,
+		OscillatorLevels:           append([]float64(nil), cfg.OscillatorLevels...),
+		SaturatorAmount:            cfg.SaturatorAmount,
+		FilterBands:                append([]float64(nil), cfg.FilterBands...),
+		FadeDuration:               cfg.FadeDuration,
+		SmoothFrequencyTransitions: cfg.SmoothFrequencyTransitions,
+		ReverbAmount:               cfg.ReverbAmount,
+		ReverbDecay:                cfg.ReverbDecay,
+		DelayAmount:                cfg.DelayAmount,
+		DelayTime:                  cfg.DelayTime,
+		DelayFeedback:              cfg.DelayFeedback,
+	}
+}
+
+func (s storedSettings) settings() *synth.Settings {
+	return &synth.Settings{
+		SoundType:                  s.SoundType,
+		SampleRate:                 s.SampleRate,
+		BitDepth:                   s.BitDepth,
+		Channels:                   s.Channels,
+		StartFreq:                  s.StartFreq,
+		EndFreq:                    s.EndFreq,
+		Duration:                   s.Duration,
+		WaveformType:               s.WaveformType,
+		NoiseAmount:                s.NoiseAmount,
+		Attack:                     s.Attack,
+		Decay:                      s.Decay,
+		Sustain:                    s.Sustain,
+		Release:                    s.Release,
+		Drive:                      s.Drive,
+		FilterCutoff:               s.FilterCutoff,
+		FilterResonance:            s.FilterResonance,
+		Sweep:                      s.Sweep,
+		PitchDecay:                 s.PitchDecay,
+		NumOscillators:             s.NumOscillators,
+		OscillatorLevels:           append([]float64(nil), s.OscillatorLevels...),
+		SaturatorAmount:            s.SaturatorAmount,
+		FilterBands:                append([]float64(nil), s.FilterBands...),
+		FadeDuration:               s.FadeDuration,
+		SmoothFrequencyTransitions: s.SmoothFrequencyTransitions,
+		ReverbAmount:               s.ReverbAmount,
+		ReverbDecay:                s.ReverbDecay,
+		DelayAmount:                s.DelayAmount,
+		DelayTime:                  s.DelayTime,
+		DelayFeedback:              s.DelayFeedback,
+	}
+}
+
+// padParameter is a pad setting that can be controlled remotely, with the same range as its slider
+type padParameter struct {
+	name     string
+	min, max float64
+	field    func(cfg *synth.Settings) *float64
+}
+
+var padParameters = []padParameter{
+	{"Attack", 0.0, 1.0, func(cfg *synth.Settings) *float64 { return &cfg.Attack }},
+	{"Decay", 0.1, 1.0, func(cfg *synth.Settings) *float64 { return &cfg.Decay }},
+	{"Sustain", 0.0, 1.0, func(cfg *synth.Settings) *float64 { return &cfg.Sustain }},
+	{"Release", 0.1, 1.0, func(cfg *synth.Settings) *float64 { return &cfg.Release }},
+	{"Drive", 0.0, 1.0, func(cfg *synth.Settings) *float64 { return &cfg.Drive }},
+	{"Filter Cutoff", 1000, 8000, func(cfg *synth.Settings) *float64 { return &cfg.FilterCutoff }},
+	{"Sweep", 0.1, 2.0, func(cfg *synth.Settings) *float64 { return &cfg.Sweep }},
+	{"Pitch Decay", 0.1, 1.5, func(cfg *synth.Settings) *float64 { return &cfg.PitchDecay }},
+}
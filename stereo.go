@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	widthDelay = 0
+	widthHaas  = 1
+	widthNoise = 2
+
+	// stereoWidthDelay is how late the copy of a sound that makes a stereo pad wide is, in seconds
+	stereoWidthDelay = 0.012
+	// maxHaasDelay is how late the right channel is at full width, with the Haas effect, in seconds
+	maxHaasDelay = 0.015
+	// decorrelationLength is the length of the sparse noise that a sound is filtered with to make it wide, in seconds
+	decorrelationLength   = 0.02
+	decorrelationImpulses = 24
+	// monoBassFrequency is the frequency below which stereo pads are always mono, so that kicks still work in mono
+	monoBassFrequency = 120
+	// stereoVariationSeed keeps the variation of the right channel apart from the round-robin variants,
+	// which are seeded with the seed of the pad plus the variant
+	stereoVariationSeed = 1 << 32
+)
+
+var (
+	widthModes = []string{"Delay", "Haas", "Noise"}
+
+	// the mono check of the active pad is only done again when its sound changes
+	lastMonoKey         string
+	lastCorrelation     float64
+	lastMonoDifference  float64
+	lastMonoCheckFailed bool
+)
+
+// stereoSettings returns the settings that the right channel of a stereo pad is rendered with, which differ a little
+// from the settings of the left channel. The variation is seeded per pad, so that it sounds the same every time.
+func (o padOptions) stereoSettings(cfg *synth.Settings) *synth.Settings {
+	right := synth.CopySettings(cfg)
+	r := rand.New(rand.NewSource(o.Seed + stereoVariationSeed))
+	mutateSettingsWith(r, right, false, 1.0, clamp(o.StereoVariation, 0, 1)*roundRobinVariation)
+	right.WaveformType = cfg.WaveformType
+	return right
+}
+
+// stereoChannels renders the left and right channel of a stereo pad, which are made wider with the width of the pad.
+// Everything below monoBassFrequency is the same in both channels. The channels can be modified.
+func (o padOptions) stereoChannels(cfg *synth.Settings, sound padSound, sampleRate int) ([]float64, []float64, error) {
+	left, err := renderSound(cfg, sound, sampleRate)
+	if err != nil {
+		return nil, nil, err
+	}
+	right := left
+	if o.StereoVariation > 0 && sound.Sample == nil {
+		if right, err = renderSound(o.stereoSettings(cfg), sound, sampleRate); err != nil {
+			return nil, nil, err
+		}
+	}
+	left, right = widen(left, right, sampleRate, o.WidthMode, clamp(o.Width, 0, 1), o.Seed)
+	monoBass(left, right, sampleRate)
+	return left, right, nil
+}
+
+// widen returns new left and right channels, which are made wider by the given width from 0 to 1.
+// The delay and noise modes add a copy of the sound to one channel and subtract it from the other, so that the
+// channels add up to the sound again in mono. The Haas mode delays the right channel, which is wider, but not mono compatible.
+func widen(left, right []float64, sampleRate, mode int, width float64, seed int64) ([]float64, []float64) {
+	length := max(len(left), len(right))
+	mid := make([]float64, length)
+	for i := range mid {
+		if i < len(left) {
+			mid[i] += left[i] / 2
+		}
+		if i < len(right) {
+			mid[i] += right[i] / 2
+		}
+	}
+	var side []float64
+	switch mode {
+	case widthHaas:
+		delay := int(width * maxHaasDelay * float64(sampleRate))
+		wideLeft := make([]float64, length+delay)
+		wideRight := make([]float64, length+delay)
+		copy(wideLeft, left)
+		copy(wideRight[delay:], right)
+		return wideLeft, wideRight
+	case widthNoise:
+		side = decorrelate(mid, sampleRate, seed)
+	default:
+		delay := int(stereoWidthDelay * float64(sampleRate))
+		side = make([]float64, length+delay)
+		copy(side[delay:], mid)
+	}
+	wideLeft := make([]float64, len(side))
+	wideRight := make([]float64, len(side))
+	copy(wideLeft, left)
+	copy(wideRight, right)
+	for i, sample := range side {
+		wideLeft[i] += sample * width / 2
+		wideRight[i] -= sample * width / 2
+	}
+	return wideLeft, wideRight
+}
+
+// decorrelate returns the samples filtered with velvet noise, sparse impulses with random signs that fade out.
+// The result sounds like the samples, but it is hardly correlated with them. The noise depends only on the seed.
+func decorrelate(samples []float64, sampleRate int, seed int64) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	length := int(decorrelationLength * float64(sampleRate))
+	positions := make([]int, decorrelationImpulses)
+	gains := make([]float64, decorrelationImpulses)
+	energy := 0.0
+	for k := range positions {
+		// there is one impulse in each part of the noise, so that the impulses are spread out
+		positions[k] = (k*length + r.Intn(length)) / decorrelationImpulses
+		gains[k] = math.Exp(-3 * float64(positions[k]) / float64(length))
+		if r.Intn(2) == 0 {
+			gains[k] = -gains[k]
+		}
+		energy += gains[k] * gains[k]
+	}
+	out := make([]float64, len(samples)+length)
+	for k, position := range positions {
+		gain := gains[k] / math.Sqrt(energy)
+		for i, sample := range samples {
+			out[i+position] += sample * gain
+		}
+	}
+	return out
+}
+
+// monoBass makes everything below monoBassFrequency the same in both channels
+func monoBass(left, right []float64, sampleRate int) {
+	side := make([]float64, len(left))
+	for i := range side {
+		side[i] = (left[i] - right[i]) / 2
+	}
+	passFilter(monoBassFrequency, true, sampleRate).process(side)
+	for i := range side {
+		mid := (left[i] + right[i]) / 2
+		left[i], right[i] = mid+side[i], mid-side[i]
+	}
+}
+
+// renderChannels renders a pad in its format, with the channels interleaved. Stereo pads are balanced with the pan of the pad,
+// and other numbers of channels get the same sound in every channel. The returned samples must not be modified.
+func (o padOptions) renderChannels(cfg *synth.Settings, sound padSound, sampleRate int) ([]float64, error) {
+	if cfg.Channels == 2 {
+		left, right, err := o.stereoChannels(cfg, sound, sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		leftGain, rightGain := panGains(o.Pan)
+		for i := range left {
+			left[i] *= leftGain
+			right[i] *= rightGain
+		}
+		return interleave(left, right), nil
+	}
+	samples, err := renderSound(cfg, sound, sampleRate)
+	if err != nil || cfg.Channels <= 1 {
+		return samples, err
+	}
+	copies := make([][]float64, cfg.Channels)
+	for c := range copies {
+		copies[c] = samples
+	}
+	return interleave(copies...), nil
+}
+
+// interleave returns the channels as one slice, one frame after the other
+func interleave(channels ...[]float64) []float64 {
+	out := make([]float64, 0, len(channels)*len(channels[0]))
+	for i := range channels[0] {
+		for _, channel := range channels {
+			out = append(out, channel[i])
+		}
+	}
+	return out
+}
+
+// deinterleave returns the channels of interleaved samples
+func deinterleave(samples []float64, channels int) [][]float64 {
+	out := make([][]float64, channels)
+	for c := range out {
+		out[c] = make([]float64, 0, len(samples)/channels)
+	}
+	for i, sample := range samples {
+		out[i%channels] = append(out[i%channels], sample)
+	}
+	return out
+}
+
+// monoCheck returns the correlation of the channels, from -1 to 1, and how much louder the sound is when the
+// channels are mixed to mono, in LU. A negative correlation or a large loss means that the sound is not mono compatible.
+func monoCheck(left, right []float64, sampleRate int) (float64, float64) {
+	leftSum, rightSum, product := 0.0, 0.0, 0.0
+	mono := make([]float64, len(left))
+	for i := range left {
+		leftSum += left[i] * left[i]
+		rightSum += right[i] * right[i]
+		product += left[i] * right[i]
+		mono[i] = (left[i] + right[i]) / 2
+	}
+	correlation := 1.0
+	if leftSum > 0 && rightSum > 0 {
+		correlation = product / math.Sqrt(leftSum*rightSum)
+	}
+	return correlation, shortTermLoudness([][]float64{mono, mono}, sampleRate) - shortTermLoudness([][]float64{left, right}, sampleRate)
+}
+
+// activeMonoCheck returns the mono check of the active pad, and only checks it again if the sound of the pad has changed
+func activeMonoCheck() (float64, float64, bool) {
+	if key := padSoundKey(activePadIndex); key != lastMonoKey {
+		lastMonoKey = key
+		cfg := pads[activePadIndex]
+		opts := padOpts[activePadIndex]
+		left, right, err := opts.stereoChannels(cfg, opts.sound(), cfg.SampleRate)
+		lastMonoCheckFailed = err != nil
+		if err == nil {
+			lastCorrelation, lastMonoDifference = monoCheck(left, right, cfg.SampleRate)
+		}
+	}
+	return lastCorrelation, lastMonoDifference, !lastMonoCheckFailed
+}
+
+// createStereoWidget shows how wide the active pad is, and how it sounds in mono, for stereo pads
+func createStereoWidget() g.Widget {
+	if pads[activePadIndex].Channels != 2 {
+		return g.Dummy(0, 0)
+	}
+	opts := padOpts[activePadIndex]
+	width := float32(opts.Width)
+	variation := float32(opts.StereoVariation)
+	mode := int32(opts.WidthMode)
+	return g.Column(
+		g.Row(
+			g.Label("Width"),
+			g.SliderFloat(&width, 0, 1).Size(150).OnChange(func() {
+				recordSliderEdit("Width", activePadIndex)
+				padOpts[activePadIndex].Width = float64(width)
+			}),
+			g.Combo("##widthMode", widthModes[mode], widthModes, &mode).Size(80).OnChange(func() {
+				recordEdit(fmt.Sprintf("%s: Width mode", padLabel(activePadIndex)), activePadIndex)
+				padOpts[activePadIndex].WidthMode = int(mode)
+			}),
+		),
+		g.Row(
+			g.Label("L/R variation"),
+			g.SliderFloat(&variation, 0, 1).Size(150).OnChange(func() {
+				recordSliderEdit("L/R variation", activePadIndex)
+				padOpts[activePadIndex].StereoVariation = float64(variation)
+			}),
+		),
+		g.Custom(func() {
+			label := "Mono check: failed to render"
+			if correlation, difference, ok := activeMonoCheck(); ok {
+				label = fmt.Sprintf("Mono check: correlation %+.2f, %+.1f LU in mono", correlation, difference)
+				if correlation < 0 || difference < -3 {
+					label += " (not mono compatible)"
+				}
+			}
+			g.Label(label).Build()
+		}),
+	)
+}
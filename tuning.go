@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	minDetectedPitch = 20   // Hz
+	maxDetectedPitch = 2000 // Hz
+	// bodyOffset is how long after the loudest point the body of a sound is measured, when the attack is over
+	bodyOffset = 0.03 // seconds
+	bodyLength = 0.1  // seconds
+	// tuneTolerance is how close to the note a tuned pad has to be, in cents
+	tuneTolerance = 2
+	tuneAttempts  = 4
+)
+
+var (
+	noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	octaves   = []string{"0", "1", "2", "3", "4", "5"}
+
+	tuneNote   int32 = 5 // F
+	tuneOctave int32 = 1
+	tuneKey    int32 = 5
+
+	// the pitch of the active pad is only detected again when its sound changes
+	lastPitchKey string
+	lastPitch    float64
+	lastPitchOK  bool
+)
+
+// detectPitch returns the fundamental frequency of the body of a sound, with the YIN method.
+// It returns false if the body is too short or not periodic, like noise.
+func detectPitch(samples []float64, sampleRate int) (float64, bool) {
+	peak := 0
+	for i, sample := range samples {
+		if math.Abs(sample) > math.Abs(samples[peak]) {
+			peak = i
+		}
+	}
+	window := int(bodyLength * float64(sampleRate))
+	minLag := sampleRate / maxDetectedPitch
+	maxLag := sampleRate / minDetectedPitch
+	start := min(peak+int(bodyOffset*float64(sampleRate)), len(samples)-window-maxLag)
+	if start < 0 {
+		return 0, false
+	}
+	// the cumulative mean normalized difference is low for lags that are close to a period of the sound
+	difference := make([]float64, maxLag+1)
+	difference[0] = 1
+	sum := 0.0
+	for lag := 1; lag <= maxLag; lag++ {
+		d := 0.0
+		for i := start; i < start+window; i++ {
+			delta := samples[i] - samples[i+lag]
+			d += delta * delta
+		}
+		sum += d
+		if sum == 0 {
+			return 0, false
+		}
+		difference[lag] = d * float64(lag) / sum
+	}
+	best := -1
+	for lag := minLag; lag <= maxLag; lag++ {
+		if difference[lag] < 0.1 {
+			for lag < maxLag && difference[lag+1] < difference[lag] {
+				lag++
+			}
+			best = lag
+			break
+		}
+		if best < 0 || difference[lag] < difference[best] {
+			best = lag
+		}
+	}
+	if best <= 0 || difference[best] > 0.5 {
+		return 0, false
+	}
+	// a parabola through the neighbours finds the period between two samples
+	period := float64(best)
+	if best > 1 && best < maxLag {
+		a, b, c := difference[best-1], difference[best], difference[best+1]
+		if denominator := a - 2*b + c; denominator > 0 {
+			period += (a - c) / (2 * denominator)
+		}
+	}
+	return float64(sampleRate) / period, true
+}
+
+// noteNumber returns the MIDI note number of a frequency, as a fraction, where 69 is A4 at 440 Hz
+func noteNumber(frequency float64) float64 {
+	return 69 + 12*math.Log2(frequency/440)
+}
+
+func noteFrequency(note int) float64 {
+	return 440 * math.Pow(2, float64(note-69)/12)
+}
+
+// noteName returns the nearest note of a frequency, like "F1", and how far from that note the frequency is, in cents
+func noteName(frequency float64) (string, float64) {
+	number := noteNumber(frequency)
+	nearest := int(math.Round(number))
+	return fmt.Sprintf("%s%d", noteNames[(nearest%12+12)%12], nearest/12-1), (number - float64(nearest)) * 100
+}
+
+// padPitch returns the fundamental frequency of a pad, as it is played, with the layers and effects
+func padPitch(padIndex int) (float64, bool) {
+	cfg := pads[padIndex]
+	samples, err := renderSound(cfg, padOpts[padIndex].sound(), cfg.SampleRate)
+	if err != nil {
+		return 0, false
+	}
+	return detectPitch(samples, cfg.SampleRate)
+}
+
+// transposePad changes the pitch of every layer of a pad, and of its sample, by the given ratio
+func transposePad(padIndex int, ratio float64) {
+	opts := &padOpts[padIndex]
+	for layer := 0; layer <= len(opts.Layers); layer++ {
+		cfg := synth.CopySettings(layerSettings(padIndex, layer))
+		cfg.StartFreq *= ratio
+		cfg.EndFreq *= ratio
+		setLayerSettings(padIndex, layer, cfg)
+	}
+	if opts.Sample != nil {
+		opts.Sample.Pitch = clamp(opts.Sample.Pitch+12*math.Log2(ratio), -maxSamplePitch, maxSamplePitch)
+	}
+}
+
+// tunePad transposes a pad until its pitch is the given note, and returns how many cents it is off after that.
+// The detected pitch does not follow the settings exactly, so it is measured again after each attempt.
+func tunePad(padIndex, note int) (float64, error) {
+	target := noteFrequency(note)
+	for attempt := 0; ; attempt++ {
+		pitch, ok := padPitch(padIndex)
+		if !ok {
+			return 0, fmt.Errorf("%s has no pitch that can be detected", padLabel(padIndex))
+		}
+		cents := 1200 * math.Log2(pitch/target)
+		if math.Abs(cents) < tuneTolerance || attempt == tuneAttempts {
+			return cents, nil
+		}
+		transposePad(padIndex, target/pitch)
+	}
+}
+
+// nearestKeyNote returns the note of the key, in the octave that is closest to the frequency
+func nearestKeyNote(frequency float64, key int) int {
+	return key + 12*int(math.Round((noteNumber(frequency)-float64(key))/12))
+}
+
+// tuneKicks tunes every synthesized kick to the root note of the given key, in the octave that is closest to its pitch
+func tuneKicks(key int) {
+	var kicks []int
+	for i := 0; i < numPads; i++ {
+		if pads[i].SoundType == synth.Kick && padOpts[i].Sample == nil {
+			kicks = append(kicks, i)
+		}
+	}
+	if len(kicks) == 0 {
+		setStatusMessage("There are no kick pads to tune")
+		return
+	}
+	recordEdit(fmt.Sprintf("Tune kicks to %s", noteNames[key]), kicks...)
+	tuned := 0
+	for _, padIndex := range kicks {
+		pitch, ok := padPitch(padIndex)
+		if !ok {
+			continue
+		}
+		if _, err := tunePad(padIndex, nearestKeyNote(pitch, key)); err == nil {
+			tuned++
+		}
+	}
+	setStatusMessage(fmt.Sprintf("Tuned %d of %d kicks to %s", tuned, len(kicks), noteNames[key]))
+}
+
+// activePadPitch returns the pitch of the active pad, and only detects it again if the sound of the pad has changed
+func activePadPitch() (float64, bool) {
+	if key := padSoundKey(activePadIndex); key != lastPitchKey {
+		lastPitchKey = key
+		lastPitch, lastPitchOK = padPitch(activePadIndex)
+	}
+	return lastPitch, lastPitchOK
+}
+
+// createTuningWidget shows the pitch of the active pad, which is only detected while the widget is open
+func createTuningWidget() g.Widget {
+	return g.TreeNode("Tuning").Layout(
+		g.Custom(func() {
+			pitchLabel := "Pitch: none"
+			if pitch, ok := activePadPitch(); ok {
+				name, cents := noteName(pitch)
+				pitchLabel = fmt.Sprintf("Pitch: %.1f Hz, %s %+.0f cents", pitch, name, cents)
+			}
+			g.Label(pitchLabel).Build()
+		}),
+		g.Row(
+			g.Combo("##tuneNote", noteNames[tuneNote], noteNames, &tuneNote).Size(50),
+			g.Combo("##tuneOctave", octaves[tuneOctave], octaves, &tuneOctave).Size(40),
+			g.Button("Tune to").OnClick(func() {
+				note := int(tuneNote) + 12*(int(tuneOctave)+1)
+				recordEdit(fmt.Sprintf("%s: Tune", padLabel(activePadIndex)), activePadIndex)
+				name, _ := noteName(noteFrequency(note))
+				if cents, err := tunePad(activePadIndex, note); err != nil {
+					setStatusMessage(fmt.Sprintf("Error: %v", err))
+				} else {
+					setStatusMessage(fmt.Sprintf("Tuned %s to %s %+.0f cents", padLabel(activePadIndex), name, cents))
+				}
+			}),
+		),
+		g.Row(
+			g.Combo("##tuneKey", noteNames[tuneKey], noteNames, &tuneKey).Size(50),
+			g.Button("Tune all kicks to key").OnClick(func() {
+				tuneKicks(int(tuneKey))
+			}),
+		),
+	)
+}
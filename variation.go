@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+
+	g "github.com/AllenDang/giu"
+	"github.com/xyproto/synth"
+)
+
+const (
+	maxVelocityLayers    = 8
+	maxRoundRobin        = 8
+	roundRobinVariation  = 0.03
+	minVelocityAmplitude = 0.25
+)
+
+type padOptions struct {
+	VelocityLayers int
+	RoundRobin     int
+	Seed           int64
+	// Gain is in dB, and Pan is from -1 (left) to 1 (right)
+	Gain       float64
+	Pan        float64
+	Mute       bool
+	Solo       bool
+	ChokeGroup int
+	Effects    padEffects
+	// Width is how wide a stereo pad is, from 0 to 1, with widthDelay, widthHaas or widthNoise as the WidthMode
+	Width     float64
+	WidthMode int
+	// StereoVariation is how different the settings of the right channel of a stereo pad are, from 0 to 1
+	StereoVariation float64
+	// LayerMix is how the settings of the pad are mixed with the other layers
+	LayerMix layerMix
+	Layers   []synthLayer
+	// Sample is played instead of the settings of the pad, if it is set
+	Sample *padSample
+}
+
+var (
+	padOpts         [numPads]padOptions
+	roundRobinIndex [numPads]int
+	playVelocity    float32 = 1.0
+)
+
+// randomSource makes it possible to mutate settings with either the global or a seeded random number generator
+type randomSource interface {
+	Float64() float64
+	Intn(n int) int
+}
+
+type globalRandom struct{}
+
+func (globalRandom) Float64() float64 { return rand.Float64() }
+
+func (globalRandom) Intn(n int) int { return rand.Intn(n) }
+
+func newPadOptions() padOptions {
+	return padOptions{VelocityLayers: 1, RoundRobin: 1, Seed: rand.Int63(), Effects: defaultEffects()}
+}
+
+// velocitySettings returns a copy of cfg that is rendered as if the pad was hit with the given velocity (0..1]
+func velocitySettings(cfg *synth.Settings, velocity float64) *synth.Settings {
+	v := synth.CopySettings(cfg)
+	v.Drive = clamp(cfg.Drive*(0.5+0.5*velocity), minDrive, maxDrive)
+	v.FilterCutoff = math.Max(cfg.FilterCutoff*(0.5+0.5*velocity), minFilterCutoff)
+	return v
+}
+
+func velocityAmplitude(velocity float64) float64 {
+	return minVelocityAmplitude + (1-minVelocityAmplitude)*velocity
+}
+
+// layerVelocity returns the velocity that the given layer is rendered with, the top layer is always 1
+func layerVelocity(layer, layers int) float64 {
+	return float64(layer+1) / float64(layers)
+}
+
+// velocityLayer returns the layer that should be played for the given velocity
+func velocityLayer(velocity float64, layers int) int {
+	layer := int(math.Ceil(velocity*float64(layers))) - 1
+	if layer < 0 {
+		return 0
+	}
+	if layer >= layers {
+		return layers - 1
+	}
+	return layer
+}
+
+// variationSettings returns the settings for one velocity layer and round-robin variant of a pad.
+// Variant 0 is the pad itself, the other variants are small mutations that are seeded per pad,
+// so that they sound the same every time they are rendered.
+func variationSettings(padIndex, layer, variant int) *synth.Settings {
+	opts := padOpts[padIndex]
+	cfg := velocitySettings(pads[padIndex], layerVelocity(layer, opts.VelocityLayers))
+	if variant > 0 {
+		r := rand.New(rand.NewSource(opts.Seed + int64(variant)))
+		mutateSettingsWith(r, cfg, false, 1.0, roundRobinVariation)
+		cfg.WaveformType = pads[padIndex].WaveformType
+	}
+	return cfg
+}
+
+// variationSound returns the other layers and the effects of a pad, for one velocity layer
+func variationSound(padIndex, layer int) padSound {
+	opts := padOpts[padIndex]
+	return opts.sound().withVelocity(layerVelocity(layer, opts.VelocityLayers))
+}
+
+// renderVariation renders one velocity layer and round-robin variant of a pad, in the format of the pad.
+// The channels of stereo pads are interleaved.
+func renderVariation(padIndex, layer, variant int) ([]float64, *synth.Settings, error) {
+	cfg := variationSettings(padIndex, layer, variant)
+	rendered, err := padOpts[padIndex].renderChannels(cfg, variationSound(padIndex, layer), cfg.SampleRate)
+	if err != nil {
+		return nil, nil, err
+	}
+	amplitude := velocityAmplitude(layerVelocity(layer, padOpts[padIndex].VelocityLayers))
+	samples := make([]float64, len(rendered))
+	for i, sample := range rendered {
+		samples[i] = sample * amplitude
+	}
+	return samples, cfg, nil
+}
+
+func variationFileName(padIndex, layer, variant int) string {
+	cfg := pads[padIndex]
+	if padOpts[padIndex].RoundRobin > 1 {
+		return fmt.Sprintf("%02d_%s_v%d_rr%d.wav", padIndex+1, cfg.SoundType, layer+1, variant+1)
+	}
+	return fmt.Sprintf("%02d_%s_v%d.wav", padIndex+1, cfg.SoundType, layer+1)
+}
+
+// exportVariations renders every velocity layer and round-robin variant of a pad to numbered files.
+// They are all normalized with the same gain as the pad itself, the top layer, so that the softer layers stay softer.
+func exportVariations(padIndex int, directory string) ([]string, error) {
+	opts := padOpts[padIndex]
+	top, topCfg, err := renderVariation(padIndex, opts.VelocityLayers-1, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not render %s: %v", padLabel(padIndex), err)
+	}
+	gain := exportGain(top, topCfg)
+	var fileNames []string
+	for layer := 0; layer < opts.VelocityLayers; layer++ {
+		for variant := 0; variant < opts.RoundRobin; variant++ {
+			samples, cfg, err := renderVariation(padIndex, layer, variant)
+			if err != nil {
+				return fileNames, fmt.Errorf("could not render %s: %v", padLabel(padIndex), err)
+			}
+			samples = finishExport(samples, cfg, gain, padDitherSeed(padIndex, layer*opts.RoundRobin+variant))
+			fileName := filepath.Join(directory, variationFileName(padIndex, layer, variant))
+			if err := writeWav(fileName, samples, cfg.SampleRate, cfg.BitDepth, cfg.Channels); err != nil {
+				return fileNames, err
+			}
+			if err := embedSettings(fileName, cfg, opts); err != nil {
+				return fileNames, err
+			}
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	return fileNames, nil
+}
+
+// padVariation picks the velocity layer that matches the given velocity, and the next round-robin variant.
+// The layer is rendered for the top velocity of the layer, so the returned gain scales the volume to the exact velocity.
+func padVariation(padIndex int, velocity float64) (layer, variant int, gain float64) {
+	opts := padOpts[padIndex]
+	variant = roundRobinIndex[padIndex] % opts.RoundRobin
+	roundRobinIndex[padIndex] = variant + 1
+	layer = velocityLayer(velocity, opts.VelocityLayers)
+	gain = velocityAmplitude(velocity) / velocityAmplitude(layerVelocity(layer, opts.VelocityLayers))
+	return layer, variant, gain
+}
+
+// renderPad renders the velocity layer that matches the given velocity, cycling through the round-robin variants.
+// The volume follows the velocity.
+func renderPad(padIndex int, velocity float64) ([]float64, *synth.Settings, error) {
+	layer, variant, gain := padVariation(padIndex, velocity)
+	samples, cfg, err := renderVariation(padIndex, layer, variant)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range samples {
+		samples[i] *= gain
+	}
+	return samples, cfg, nil
+}
+
+// playPad picks a variation of a pad, see renderPad, and returns a function that plays it from the render cache,
+// with the gain, pan and choke group of the pad. It must be called from the GUI loop, where it copies the pad, so that
+// the returned function can render and play the copy in the background, while the pad is changed.
+func playPad(padIndex int, velocity float64) func() error {
+	if !audible(padIndex) {
+		return func() error { return nil }
+	}
+	layer, variant, _ := padVariation(padIndex, velocity)
+	opts := padOpts[padIndex].clone()
+	cfg := variationSettings(padIndex, layer, variant)
+	sound := opts.sound().withVelocity(layerVelocity(layer, opts.VelocityLayers))
+	return func() error {
+		return opts.play(padIndex, cfg, sound, velocity)
+	}
+}
+
+// play renders and plays a copy of a pad, see playPad
+func (o padOptions) play(padIndex int, cfg *synth.Settings, sound padSound, velocity float64) error {
+	gain := velocityAmplitude(velocity) * math.Pow(10, o.Gain/20)
+	left, right := panGains(o.Pan)
+	v := &voice{
+		padIndex:   padIndex,
+		chokeGroup: o.ChokeGroup,
+		left:       left * gain,
+		right:      right * gain,
+	}
+	var err error
+	if cfg.Channels == 2 {
+		v.samples, v.rightSamples, err = o.stereoChannels(cfg, sound, mixerSampleRate)
+	} else {
+		v.samples, err = renderSound(cfg, sound, mixerSampleRate)
+	}
+	if err != nil {
+		return err
+	}
+	audioMixer.trigger(v)
+	return nil
+}
+
+func createVariationWidget() g.Widget {
+	velocityLayers := int32(padOpts[activePadIndex].VelocityLayers)
+	roundRobin := int32(padOpts[activePadIndex].RoundRobin)
+	return g.Column(
+		g.Label(fmt.Sprintf("%s variation:", padLabel(activePadIndex))),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Label("Velocity layers"),
+			g.SliderInt(&velocityLayers, 1, maxVelocityLayers).Size(150).OnChange(func() {
+				recordSliderEdit("Velocity layers", activePadIndex)
+				padOpts[activePadIndex].VelocityLayers = int(velocityLayers)
+			}),
+		),
+		g.Row(
+			g.Label("Round robin"),
+			g.SliderInt(&roundRobin, 1, maxRoundRobin).Size(150).OnChange(func() {
+				recordSliderEdit("Round robin", activePadIndex)
+				padOpts[activePadIndex].RoundRobin = int(roundRobin)
+				roundRobinIndex[activePadIndex] = 0
+			}),
+		),
+		g.Row(
+			g.Label("Play velocity"),
+			g.SliderFloat(&playVelocity, 0.01, 1.0).Size(150),
+		),
+		g.Dummy(30, 0),
+		g.Row(
+			g.Button("Play").OnClick(func() {
+				play := playPad(activePadIndex, float64(playVelocity))
+				go func() {
+					if err := play(); err != nil {
+						setStatusMessage(fmt.Sprintf("Error: Failed to play sound: %v", err))
+					}
+				}()
+			}),
+			g.Button("New variations").OnClick(func() {
+				recordEdit(fmt.Sprintf("%s: New variations", padLabel(activePadIndex)), activePadIndex)
+				padOpts[activePadIndex].Seed = rand.Int63()
+			}),
+			g.Button("Export variations").OnClick(func() {
+				fileNames, err := exportVariations(activePadIndex, ".")
+				if err != nil {
+					setStatusMessage(fmt.Sprintf("Error: Failed to export variations: %v", err))
+				} else {
+					setStatusMessage(fmt.Sprintf("Exported %d files for %s", len(fileNames), padLabel(activePadIndex)))
+				}
+			}),
+		),
+	)
+}
@@ -0,0 +1,294 @@
+// The browser UI for "kickpad serve". Everything goes through the same HTTP API that scripts can use.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+let pads = [];
+let parameters = [];
+let activePad = 1;
+let job = null;
+let targetFile = null;
+let audioContext = null;
+
+function setStatus(message) {
+  $("status").textContent = message;
+}
+
+async function api(method, path, body) {
+  const options = { method };
+  if (body !== undefined) {
+    options.body = body instanceof Blob ? body : JSON.stringify(body);
+  }
+  const response = await fetch(path, options);
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      message = (await response.json()).error;
+    } catch (e) {}
+    throw new Error(message);
+  }
+  const type = response.headers.get("Content-Type") || "";
+  return type.startsWith("application/json") ? response.json() : response.blob();
+}
+
+// textColor returns black or white, depending on how light the pad color is, like in the desktop UI
+function textColor(hex) {
+  const r = parseInt(hex.slice(1, 3), 16);
+  const g = parseInt(hex.slice(3, 5), 16);
+  const b = parseInt(hex.slice(5, 7), 16);
+  return 0.299 * r + 0.587 * g + 0.114 * b < 128 ? "#fff" : "#000";
+}
+
+function renderPads() {
+  const grid = $("pads");
+  grid.replaceChildren();
+  for (const pad of pads) {
+    const button = document.createElement("button");
+    button.className = "pad" + (pad.Pad === activePad ? " active" : "");
+    button.style.background = pad.Color;
+    button.style.color = textColor(pad.Color);
+    button.textContent = pad.Label;
+    button.title = pad.Type;
+    button.onclick = () => {
+      activePad = pad.Pad;
+      renderPads();
+      renderEditor();
+      play(pad.Pad);
+    };
+    grid.append(button);
+  }
+}
+
+function renderEditor() {
+  const pad = pads[activePad - 1];
+  $("padTitle").textContent = `${pad.Label} (${pad.Type})`;
+  $("download").href = `pads/${activePad}/wav`;
+  $("download").download = `pad${activePad}.wav`;
+  const sliders = $("sliders");
+  sliders.replaceChildren();
+  for (const p of parameters) {
+    const row = document.createElement("label");
+    row.className = "slider";
+    const input = document.createElement("input");
+    input.type = "range";
+    input.min = p.Min;
+    input.max = p.Max;
+    input.step = (p.Max - p.Min) / 1000;
+    input.value = pad.Settings[p.Field];
+    const value = document.createElement("span");
+    value.textContent = Number(input.value).toFixed(2);
+    input.oninput = () => {
+      value.textContent = Number(input.value).toFixed(2);
+      setParameter(pad.Pad, p.Field, Number(input.value));
+    };
+    input.onchange = () => plotPad(pad.Pad);
+    row.append(p.Name, input, value);
+    sliders.append(row);
+  }
+  plotPad(activePad);
+}
+
+// pendingValues holds the latest slider values that have not been sent yet, so that only one request is in flight
+const pendingValues = new Map();
+let sending = false;
+
+async function setParameter(padNumber, field, value) {
+  pendingValues.set(`${padNumber}/${field}`, value);
+  if (sending) {
+    return;
+  }
+  sending = true;
+  try {
+    for (const [key, v] of pendingValues) {
+      pendingValues.delete(key);
+      const [n, f] = key.split("/");
+      pads[Number(n) - 1] = await api("PUT", `pads/${n}/${f}`, v);
+    }
+    renderPads();
+  } catch (e) {
+    setStatus(`Error: ${e.message}`);
+  } finally {
+    sending = false;
+  }
+}
+
+function context() {
+  if (!audioContext) {
+    audioContext = new AudioContext();
+  }
+  return audioContext;
+}
+
+async function decode(blob) {
+  return context().decodeAudioData(await blob.arrayBuffer());
+}
+
+function plot(canvas, audio) {
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  if (!audio) {
+    return;
+  }
+  const samples = audio.getChannelData(0);
+  const mid = canvas.height / 2;
+  const step = Math.max(1, Math.floor(samples.length / canvas.width));
+  ctx.strokeStyle = "#8cf";
+  ctx.beginPath();
+  for (let x = 0; x < canvas.width; x++) {
+    let min = 1;
+    let max = -1;
+    for (let i = x * step; i < (x + 1) * step && i < samples.length; i++) {
+      min = Math.min(min, samples[i]);
+      max = Math.max(max, samples[i]);
+    }
+    if (min > max) {
+      break;
+    }
+    ctx.moveTo(x + 0.5, mid - max * mid);
+    ctx.lineTo(x + 0.5, mid - min * mid);
+  }
+  ctx.stroke();
+}
+
+async function plotPad(padNumber) {
+  try {
+    const wav = await api("GET", `pads/${padNumber}/wav`);
+    if (padNumber === activePad) {
+      plot($("padPlot"), await decode(wav));
+    }
+  } catch (e) {
+    setStatus(`Error: Failed to render ${pads[padNumber - 1].Label}: ${e.message}`);
+  }
+}
+
+function playBlob(blob) {
+  const url = URL.createObjectURL(blob);
+  const audio = new Audio(url);
+  audio.onended = () => URL.revokeObjectURL(url);
+  return audio.play();
+}
+
+async function play(padNumber) {
+  try {
+    await playBlob(await api("GET", `pads/${padNumber}/wav`));
+    setStatus(`Playing sound from ${pads[padNumber - 1].Label}`);
+  } catch (e) {
+    setStatus(`Error: Failed to play sound: ${e.message}`);
+  }
+}
+
+async function randomize() {
+  try {
+    pads[activePad - 1] = await api("POST", `pads/${activePad}/randomize`);
+    renderPads();
+    renderEditor();
+    play(activePad);
+  } catch (e) {
+    setStatus(`Error: ${e.message}`);
+  }
+}
+
+async function loadTarget() {
+  targetFile = $("target").files[0] || null;
+  $("train").disabled = !targetFile;
+  $("playTarget").disabled = !targetFile;
+  plot($("targetPlot"), null);
+  if (!targetFile) {
+    return;
+  }
+  try {
+    plot($("targetPlot"), await decode(targetFile));
+  } catch (e) {
+    setStatus(`${targetFile.name} can not be shown by this browser, but it can still be used for training`);
+  }
+}
+
+async function startTraining() {
+  const query = new URLSearchParams({
+    rate: $("rate").value,
+    bits: $("bits").value,
+    allWaveforms: $("allWaveforms").checked,
+    loudnessInvariant: $("loudnessInvariant").checked,
+  });
+  try {
+    job = await api("POST", `jobs?${query}`, targetFile);
+    setStatus("Training started...");
+    updateTrainingButtons();
+    pollJob(job.ID);
+  } catch (e) {
+    setStatus(`Error: Failed to start training: ${e.message}`);
+  }
+}
+
+async function pollJob(id) {
+  let lastGeneration = -1;
+  while (job && job.ID === id) {
+    try {
+      job = await api("GET", `jobs/${id}`);
+    } catch (e) {
+      setStatus(`Error: ${e.message}`);
+      return;
+    }
+    if (job.Generation !== lastGeneration && job.Settings) {
+      lastGeneration = job.Generation;
+      setStatus(`Generation ${job.Generation}: Best fitness = ${job.Fitness.toFixed(6)}`);
+      api("POST", "render", job.Settings)
+        .then(decode)
+        .then((audio) => plot($("padPlot"), audio))
+        .catch(() => {});
+    }
+    updateTrainingButtons();
+    if (job.State !== "running") {
+      setStatus(job.Result || `Training ${job.State}.`);
+      return;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 500));
+  }
+}
+
+function updateTrainingButtons() {
+  const running = job && job.State === "running";
+  $("train").disabled = !targetFile || running;
+  $("stop").disabled = !running;
+  $("apply").disabled = !job || !job.Settings;
+}
+
+async function stopTraining() {
+  try {
+    job = await api("DELETE", `jobs/${job.ID}`);
+    updateTrainingButtons();
+  } catch (e) {
+    setStatus(`Error: ${e.message}`);
+  }
+}
+
+async function applyTraining() {
+  try {
+    pads[activePad - 1] = await api("POST", `jobs/${job.ID}/apply?pad=${activePad}`);
+    renderPads();
+    renderEditor();
+    setStatus(`Applied the training result to ${pads[activePad - 1].Label}`);
+  } catch (e) {
+    setStatus(`Error: ${e.message}`);
+  }
+}
+
+async function init() {
+  $("play").onclick = () => play(activePad);
+  $("randomize").onclick = randomize;
+  $("target").onchange = loadTarget;
+  $("train").onclick = startTraining;
+  $("stop").onclick = stopTraining;
+  $("apply").onclick = applyTraining;
+  $("playTarget").onclick = () => playBlob(targetFile);
+  try {
+    [pads, parameters] = await Promise.all([api("GET", "pads"), api("GET", "parameters")]);
+  } catch (e) {
+    setStatus(`Error: Failed to load the pads: ${e.message}`);
+    return;
+  }
+  renderPads();
+  renderEditor();
+}
+
+init();
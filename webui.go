@@ -0,0 +1,20 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the browser UI, which uses the HTTP API for everything, so that it works on machines without a desktop
+//
+//go:embed web
+var webFiles embed.FS
+
+func webHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // the directory is embedded, so this can not happen
+	}
+	return http.FileServerFS(files)
+}